@@ -0,0 +1,39 @@
+package config
+
+import (
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// IPExtractor xác định IP của client cho c.RealIP() (throttle đăng nhập, security_events, log truy cập).
+// Mặc định dùng địa chỉ kết nối trực tiếp và bỏ qua X-Forwarded-For/X-Real-IP do client tự gửi.
+// Khi chạy sau reverse proxy, đặt TRUSTED_PROXIES (danh sách CIDR cách nhau bởi dấu phẩy)
+// để chỉ tin X-Forwarded-For do các proxy này thêm vào.
+func IPExtractor() echo.IPExtractor {
+	value := os.Getenv("TRUSTED_PROXIES")
+	if strings.TrimSpace(value) == "" {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range strings.Split(value, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatalf("❌ Invalid TRUSTED_PROXIES entry %q: %v", cidr, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
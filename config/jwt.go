@@ -0,0 +1,49 @@
+package config
+
+import (
+	"cms-backend/utils"
+	"crypto/ed25519"
+	"crypto/rand"
+	"log"
+	"os"
+)
+
+// InitSigner nạp khoá ký JWT từ thư mục JWT_KEYS_DIR, dùng khoá JWT_ACTIVE_KID để ký.
+// Khi chưa cấu hình thư mục khoá (chỉ cho phép ngoài production), một khoá Ed25519
+// tạm thời được sinh ra và mọi token sẽ mất hiệu lực khi khởi động lại.
+func InitSigner() {
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		issuer = "cms-backend"
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("APP_ENV") == "production" {
+			log.Fatal("❌ JWT_KEYS_DIR is required in production")
+		}
+
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal("❌ Failed to generate JWT signing key: ", err)
+		}
+		signer, err := utils.NewSigner(issuer, "dev-ephemeral", key, nil)
+		if err != nil {
+			log.Fatal("❌ Failed to create JWT signer: ", err)
+		}
+		log.Println("⚠️  JWT_KEYS_DIR not set, using an ephemeral signing key")
+		utils.SetSigner(signer)
+		return
+	}
+
+	activeKID := os.Getenv("JWT_ACTIVE_KID")
+	if activeKID == "" {
+		log.Fatal("❌ JWT_ACTIVE_KID is required when JWT_KEYS_DIR is set")
+	}
+
+	signer, err := utils.LoadSignerFromDir(dir, activeKID, issuer)
+	if err != nil {
+		log.Fatal("❌ Failed to load JWT keys: ", err)
+	}
+	utils.SetSigner(signer)
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"cms-backend/utils"
+	"log"
+	"os"
+)
+
+var Mailer utils.Mailer
+
+// InitMailer khởi tạo Mailer theo biến môi trường MAIL_DRIVER ("smtp" hoặc "file").
+// Ở môi trường production (APP_ENV=production) bắt buộc dùng SMTP để token
+// đặt lại mật khẩu không bị ghi ra đĩa.
+func InitMailer() {
+	driver := os.Getenv("MAIL_DRIVER")
+	if driver == "" {
+		driver = "file"
+	}
+
+	switch driver {
+	case "smtp":
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		Mailer = &utils.SMTPMailer{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		}
+	case "file":
+		if os.Getenv("APP_ENV") == "production" {
+			log.Fatal("❌ MAIL_DRIVER=file is not allowed in production")
+		}
+		dir := os.Getenv("MAIL_OUTBOX_DIR")
+		if dir == "" {
+			dir = "outbox"
+		}
+		Mailer = &utils.FileMailer{Dir: dir}
+	default:
+		log.Fatalf("❌ Unknown MAIL_DRIVER: %s", driver)
+	}
+}
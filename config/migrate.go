@@ -0,0 +1,19 @@
+package config
+
+import "log"
+
+// MigrateAttendanceSchema bổ sung index cho bảng attendance có sẵn (không được quản lý bởi AutoMigrate).
+// Các câu lệnh đều idempotent nên có thể chạy lại mỗi lần khởi động.
+func MigrateAttendanceSchema() {
+	// Mỗi sinh viên chỉ có một bản ghi điểm danh cho mỗi buổi học. Nếu dữ liệu cũ đang bị trùng,
+	// index không tạo được nhưng các luồng ghi vẫn tự khoá theo (schedule_id, student_id).
+	if err := DB.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_attendance_schedule_student
+		ON attendance (schedule_id, student_id)`).Error; err != nil {
+		log.Printf("⚠️  Could not create unique index on attendance(schedule_id, student_id): %v", err)
+	}
+
+	// Kênh ghi nhận điểm danh (camera, qr, manual, ...)
+	if err := DB.Exec(`ALTER TABLE attendance ADD COLUMN IF NOT EXISTS channel varchar(20)`).Error; err != nil {
+		log.Fatalf("Error adding attendance.channel column: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// inactiveClassStudentStatuses là các trạng thái thành viên lớp không còn theo học,
+// không bị đánh vắng tự động.
+var inactiveClassStudentStatuses = []string{"inactive", "dropped", "withdrawn"}
+
+// markAbsentees tạo bản ghi "absent" cho mọi sinh viên đang theo học chưa có bản ghi điểm danh
+// ở các buổi học đã kết thúc trước thời điểm now. Chỉ xét các buổi kết thúc trong khoảng lookback
+// (0 = toàn bộ lịch sử). Câu lệnh idempotent: chạy lại không tạo bản ghi trùng.
+func markAbsentees(db *gorm.DB, now time.Time, lookback time.Duration) (int64, error) {
+	var inserted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Chỉ một instance chạy job tại một thời điểm
+		var acquired bool
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(hashtext('attendance:mark-absent'))").Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+
+		since := time.Time{}
+		if lookback > 0 {
+			since = now.Add(-lookback)
+		}
+
+		// Chỉ khoá các buổi học còn sinh viên chưa có bản ghi
+		var scheduleIDs []uuid.UUID
+		if err := tx.Raw(`
+			SELECT DISTINCT s.schedule_id
+			FROM schedules s
+			JOIN class_students cs ON cs.class_id = s.class_id
+			WHERE s.end_time <= ? AND s.end_time > ?
+				AND COALESCE(cs.status, '') NOT IN ?
+				AND NOT EXISTS (
+					SELECT 1 FROM attendance a
+					WHERE a.schedule_id = s.schedule_id AND a.student_id = cs.student_id
+				)`,
+			now, since, inactiveClassStudentStatuses).Scan(&scheduleIDs).Error; err != nil {
+			return err
+		}
+		if len(scheduleIDs) == 0 {
+			return nil
+		}
+		if err := lockAttendance(tx, scheduleIDs...); err != nil {
+			return err
+		}
+
+		// Bản ghi vắng và phiên bản đầu tiên trong lịch sử được tạo trong cùng một câu lệnh
+		result := tx.Exec(`
+			WITH inserted AS (
+				INSERT INTO attendance (attendance_id, schedule_id, student_id, attendance_time, status, channel)
+				SELECT gen_random_uuid(), s.schedule_id, cs.student_id, s.end_time, ?, ?
+				FROM schedules s
+				JOIN class_students cs ON cs.class_id = s.class_id
+				WHERE s.schedule_id IN ?
+					AND COALESCE(cs.status, '') NOT IN ?
+					AND NOT EXISTS (
+						SELECT 1 FROM attendance_locks l
+						WHERE l.schedule_id = s.schedule_id AND l.finalized_at IS NOT NULL
+					)
+					AND NOT EXISTS (
+						SELECT 1 FROM attendance a
+						WHERE a.schedule_id = s.schedule_id AND a.student_id = cs.student_id
+					)
+				ON CONFLICT DO NOTHING
+				RETURNING attendance_id, schedule_id, student_id, attendance_time, status, evidence_image_url, note, channel
+			)
+			INSERT INTO attendance_versions
+				(version_id, attendance_id, version, schedule_id, student_id, source, actor_role, current, created_at)
+			SELECT gen_random_uuid(), i.attendance_id, 1, i.schedule_id, i.student_id, ?, ?, to_jsonb(i), ?
+			FROM inserted i`,
+			models.AttendanceStatusAbsent, models.AttendanceChannelSystem, scheduleIDs, inactiveClassStudentStatuses,
+			models.AttendanceChannelSystem, systemActor.Role, now)
+		inserted = result.RowsAffected
+		return result.Error
+	})
+	return inserted, err
+}
+
+// StartAbsenceJob chạy định kỳ việc đánh dấu vắng cho các buổi học đã kết thúc.
+// Chu kỳ đọc từ ABSENCE_JOB_INTERVAL (mặc định 5m, 0 để tắt), khoảng quét từ ABSENCE_JOB_LOOKBACK.
+func StartAbsenceJob() {
+	interval := utils.GetEnvDuration("ABSENCE_JOB_INTERVAL", 5*time.Minute)
+	if interval <= 0 {
+		log.Println("Absence job disabled")
+		return
+	}
+	lookback := utils.GetEnvDuration("ABSENCE_JOB_LOOKBACK", 7*24*time.Hour)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			inserted, err := markAbsentees(config.DB, time.Now(), lookback)
+			if err != nil {
+				log.Printf("Absence job failed: %v", err)
+			} else if inserted > 0 {
+				log.Printf("Absence job marked %d students absent", inserted)
+			}
+			<-ticker.C
+		}
+	}()
+}
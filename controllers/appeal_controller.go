@@ -0,0 +1,403 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// appealActor trả về user_id và role của người thao tác từ JWT.
+func appealActor(c echo.Context) (*uuid.UUID, string, jwt.MapClaims) {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	var actorID *uuid.UUID
+	if id, err := uuid.Parse(claimString(claims, "user_id")); err == nil {
+		actorID = &id
+	}
+	return actorID, claimString(claims, "role"), claims
+}
+
+// SubmitAppeal cho phép sinh viên phúc khảo một bản ghi điểm danh vắng/đi muộn của chính mình.
+// Đơn phải được gửi trong APPEAL_WINDOW (mặc định 7 ngày) sau khi buổi học kết thúc.
+func SubmitAppeal(c echo.Context) error {
+	actorID, role, _ := appealActor(c)
+	if actorID == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
+	}
+
+	var input struct {
+		RequestedStatus string `json:"requested_status"`
+		Reason          string `json:"reason"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "reason is required"})
+	}
+	if input.RequestedStatus == "" {
+		input.RequestedStatus = models.AttendanceStatusPresent
+	}
+	if input.RequestedStatus != models.AttendanceStatusPresent && input.RequestedStatus != models.AttendanceStatusLate {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "requested_status must be present or late"})
+	}
+
+	var attendance models.Attendance
+	err := config.DB.First(&attendance, "attendance_id = ? AND student_id = ?", c.Param("attendance_id"), actorID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Attendance record not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve attendance"})
+	}
+	if attendance.Status != models.AttendanceStatusAbsent && attendance.Status != models.AttendanceStatusLate {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Only absent or late records can be appealed"})
+	}
+	if attendance.Status == input.RequestedStatus {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "requested_status must differ from the current status"})
+	}
+
+	var schedule models.Schedule
+	if err := config.DB.First(&schedule, "schedule_id = ?", attendance.ScheduleID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve schedule"})
+	}
+	if window := utils.GetEnvDuration("APPEAL_WINDOW", 7*24*time.Hour); time.Since(schedule.EndTime) > window {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "The appeal window for this session has closed"})
+	}
+
+	appeal := models.AttendanceAppeal{
+		AppealID:        uuid.New(),
+		AttendanceID:    attendance.AttendanceID,
+		StudentID:       attendance.StudentID,
+		ScheduleID:      attendance.ScheduleID,
+		ClassID:         schedule.ClassID,
+		RequestedStatus: input.RequestedStatus,
+		Reason:          input.Reason,
+		Status:          models.AppealStatusPending,
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		// Mỗi bản ghi điểm danh chỉ có một đơn đang chờ xử lý
+		if err := lockAttendance(tx, attendance.ScheduleID); err != nil {
+			return err
+		}
+		var pending int64
+		if err := tx.Model(&models.AttendanceAppeal{}).
+			Where("attendance_id = ? AND status = ?", attendance.AttendanceID, models.AppealStatusPending).
+			Count(&pending).Error; err != nil {
+			return err
+		}
+		if pending > 0 {
+			return echo.NewHTTPError(http.StatusConflict, "An appeal for this record is already pending")
+		}
+		if err := tx.Create(&appeal).Error; err != nil {
+			return err
+		}
+		event := models.AttendanceAppealEvent{
+			AppealID:       appeal.AppealID,
+			ActorID:        actorID,
+			ActorRole:      role,
+			Action:         "submitted",
+			Comment:        &appeal.Reason,
+			PreviousStatus: &attendance.Status,
+		}
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+		appeal.Events = []models.AttendanceAppealEvent{event}
+		return nil
+	})
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return c.JSON(httpErr.Code, map[string]interface{}{"error": httpErr.Message})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to submit appeal"})
+	}
+
+	return c.JSON(http.StatusCreated, appeal)
+}
+
+// GetMyAppeals trả về các đơn phúc khảo của sinh viên đang đăng nhập kèm lịch sử xử lý.
+func GetMyAppeals(c echo.Context) error {
+	actorID, _, _ := appealActor(c)
+
+	var appeals []models.AttendanceAppeal
+	err := config.DB.Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		Where("student_id = ?", actorID).
+		Order("created_at DESC").
+		Find(&appeals).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve appeals"})
+	}
+	return c.JSON(http.StatusOK, appeals)
+}
+
+// GetAppealQueue trả về các đơn phúc khảo (mặc định đang chờ) của các lớp giảng viên phụ trách.
+func GetAppealQueue(c echo.Context) error {
+	actorID, role, _ := appealActor(c)
+
+	status := c.QueryParam("status")
+	if status == "" {
+		status = models.AppealStatusPending
+	}
+
+	type appealQueueItem struct {
+		AppealID        uuid.UUID `json:"appeal_id"`
+		AttendanceID    uuid.UUID `json:"attendance_id"`
+		ScheduleID      uuid.UUID `json:"schedule_id"`
+		ClassID         uuid.UUID `json:"class_id"`
+		ClassName       string    `json:"class_name"`
+		StartTime       time.Time `json:"start_time"`
+		StudentID       uuid.UUID `json:"student_id"`
+		StudentCode     string    `json:"student_code"`
+		FullName        string    `json:"full_name"`
+		CurrentStatus   string    `json:"current_status"`
+		RequestedStatus string    `json:"requested_status"`
+		Reason          string    `json:"reason"`
+		Status          string    `json:"status"`
+		CreatedAt       time.Time `json:"created_at"`
+	}
+
+	query := config.DB.Table("attendance_appeals ap").
+		Select(`ap.appeal_id, ap.attendance_id, ap.schedule_id, ap.class_id, c.class_name, s.start_time,
+			ap.student_id, st.student_code, u.first_name || ' ' || u.last_name AS full_name,
+			a.status AS current_status, ap.requested_status, ap.reason, ap.status, ap.created_at`).
+		Joins("JOIN attendance a ON a.attendance_id = ap.attendance_id").
+		Joins("JOIN schedules s ON s.schedule_id = ap.schedule_id").
+		Joins("JOIN classes c ON c.class_id = ap.class_id").
+		Joins("JOIN students st ON st.student_id = ap.student_id").
+		Joins("JOIN users u ON u.user_id = ap.student_id").
+		Where("ap.status = ?", status)
+	if role != "admin" {
+		query = query.Where("c.lecturer_id = ?", actorID)
+	}
+	if classID := c.QueryParam("class_id"); classID != "" {
+		query = query.Where("ap.class_id = ?", classID)
+	}
+
+	var items []appealQueueItem
+	if err := query.Order("ap.created_at").Scan(&items).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve appeals"})
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// GetAppealDetail trả về đơn phúc khảo cùng các bằng chứng để giảng viên đối chiếu:
+// bản ghi điểm danh (ảnh minh chứng), ảnh đếm người của buổi học và lịch sử xử lý.
+// Sinh viên chỉ nhận đơn, bản ghi điểm danh và buổi học; ảnh giám sát cả phòng và sự kiện nhận dạng
+// chỉ trả cho người duyệt.
+func GetAppealDetail(c echo.Context) error {
+	actorID, role, claims := appealActor(c)
+
+	var appeal models.AttendanceAppeal
+	err := config.DB.Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		First(&appeal, "appeal_id = ?", c.Param("id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Appeal not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve appeal"})
+	}
+	reviewer := role != "student"
+	if !reviewer {
+		if actorID == nil || *actorID != appeal.StudentID {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Appeal not found"})
+		}
+	} else if allowed, err := canReviewClass(claims, appeal.ClassID); err != nil || !allowed {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "You do not teach this class"})
+	}
+
+	var attendance models.Attendance
+	if err := config.DB.First(&attendance, "attendance_id = ?", appeal.AttendanceID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve attendance"})
+	}
+	var schedule models.Schedule
+	if err := config.DB.First(&schedule, "schedule_id = ?", appeal.ScheduleID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve schedule"})
+	}
+
+	if attendance.EvidenceImageURL != nil && *attendance.EvidenceImageURL != "" {
+		logBiometricAccess(c, "evidence_image", "read", []biometricAccess{{SubjectUserID: attendance.StudentID, ResourceID: attendance.AttendanceID.String()}})
+	}
+	if !reviewer {
+		return c.JSON(http.StatusOK, echo.Map{
+			"appeal":     appeal,
+			"attendance": attendance,
+			"schedule":   schedule,
+		})
+	}
+
+	var snapshots []struct {
+		SnapshotID    string    `json:"snapshot_id"`
+		PeopleCounter int       `json:"people_counter"`
+		CapturedAt    time.Time `json:"captured_at"`
+		ImagePath     string    `json:"image_path"`
+	}
+	if err := config.DB.Table("people_count_snapshots").
+		Select("snapshot_id, people_counter, captured_at, image_path").
+		Where("schedule_id = ?", appeal.ScheduleID).
+		Order("captured_at").
+		Scan(&snapshots).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve snapshots"})
+	}
+
+	var recognitions []models.RecognitionEvent
+	if err := config.DB.Where("schedule_id = ? AND student_id = ?", appeal.ScheduleID, appeal.StudentID).
+		Order("captured_at").
+		Find(&recognitions).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve recognition events"})
+	}
+
+	// Ghi log truy cập ảnh giám sát và ảnh minh chứng của các sự kiện nhận dạng
+	var accesses []biometricAccess
+	for _, s := range snapshots {
+		if s.ImagePath != "" {
+			accesses = append(accesses, biometricAccess{SubjectUserID: appeal.StudentID, ResourceID: s.SnapshotID})
+		}
+	}
+	logBiometricAccess(c, "surveillance_snapshot", "read", accesses)
+	accesses = nil
+	for _, e := range recognitions {
+		if e.EvidenceImageURL != nil && *e.EvidenceImageURL != "" {
+			accesses = append(accesses, biometricAccess{SubjectUserID: appeal.StudentID, ResourceID: e.EventID.String()})
+		}
+	}
+	logBiometricAccess(c, "evidence_image", "read", accesses)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"appeal":             appeal,
+		"attendance":         attendance,
+		"schedule":           schedule,
+		"snapshots":          snapshots,
+		"recognition_events": recognitions,
+	})
+}
+
+// AcceptAppeal chấp nhận đơn phúc khảo và sửa trạng thái điểm danh (mặc định theo trạng thái sinh viên yêu cầu).
+func AcceptAppeal(c echo.Context) error {
+	return decideAppeal(c, models.AppealStatusAccepted)
+}
+
+// RejectAppeal từ chối đơn phúc khảo; bắt buộc có nhận xét.
+func RejectAppeal(c echo.Context) error {
+	return decideAppeal(c, models.AppealStatusRejected)
+}
+
+func decideAppeal(c echo.Context, decision string) error {
+	actorID, role, claims := appealActor(c)
+
+	var input struct {
+		Comment *string `json:"comment"`
+		Status  string  `json:"status"` // Trạng thái điểm danh sau khi chấp nhận (present/late)
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if input.Comment != nil {
+		trimmed := strings.TrimSpace(*input.Comment)
+		input.Comment = &trimmed
+	}
+	if decision == models.AppealStatusRejected && (input.Comment == nil || *input.Comment == "") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "comment is required when rejecting an appeal"})
+	}
+	if input.Status != "" && input.Status != models.AttendanceStatusPresent && input.Status != models.AttendanceStatusLate {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "status must be present or late"})
+	}
+
+	var appeal models.AttendanceAppeal
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&appeal, "appeal_id = ?", c.Param("id")).Error; err != nil {
+			return err
+		}
+		allowed, err := canReviewClass(claims, appeal.ClassID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return echo.NewHTTPError(http.StatusForbidden, "You do not teach this class")
+		}
+		if appeal.Status != models.AppealStatusPending {
+			return echo.NewHTTPError(http.StatusConflict, "Appeal has already been decided")
+		}
+
+		if err := lockAttendance(tx, appeal.ScheduleID); err != nil {
+			return err
+		}
+		var attendance models.Attendance
+		if err := tx.First(&attendance, "attendance_id = ?", appeal.AttendanceID).Error; err != nil {
+			return err
+		}
+
+		previous := attendance.Status
+		event := models.AttendanceAppealEvent{
+			AppealID:       appeal.AppealID,
+			ActorID:        actorID,
+			ActorRole:      role,
+			Action:         decision,
+			Comment:        input.Comment,
+			PreviousStatus: &previous,
+		}
+		if decision == models.AppealStatusAccepted {
+			if err := ensureAttendanceEditable(tx, appeal.ScheduleID); err != nil {
+				return err
+			}
+			newStatus := appeal.RequestedStatus
+			if input.Status != "" {
+				newStatus = input.Status
+			}
+			event.NewStatus = &newStatus
+			before := attendance
+			if err := tx.Model(&attendance).Updates(map[string]interface{}{
+				"status":  newStatus,
+				"channel": models.AttendanceChannelAppeal,
+			}).Error; err != nil {
+				return err
+			}
+			channel := models.AttendanceChannelAppeal
+			attendance.Status = newStatus
+			attendance.Channel = &channel
+			if err := recordAttendanceVersion(tx, &before, attendance, channel, attendanceActor{UserID: actorID, Role: role}); err != nil {
+				return err
+			}
+		}
+
+		now := time.Now()
+		appeal.Status = decision
+		appeal.DecidedBy = actorID
+		appeal.DecidedAt = &now
+		if err := tx.Model(&appeal).Updates(map[string]interface{}{
+			"status":     appeal.Status,
+			"decided_by": appeal.DecidedBy,
+			"decided_at": appeal.DecidedAt,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&event).Error
+	})
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return c.JSON(httpErr.Code, map[string]interface{}{"error": httpErr.Message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Appeal not found"})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to decide appeal"})
+	}
+
+	if err := config.DB.Preload("Events", func(db *gorm.DB) *gorm.DB { return db.Order("created_at") }).
+		First(&appeal, "appeal_id = ?", appeal.AppealID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve appeal"})
+	}
+	return c.JSON(http.StatusOK, appeal)
+}
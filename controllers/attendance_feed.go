@@ -0,0 +1,202 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// attendanceFeedEvent là một thay đổi điểm danh gửi qua luồng realtime, kèm thông tin sinh viên.
+type attendanceFeedEvent struct {
+	models.AttendanceVersion
+	StudentCode string `json:"student_code"`
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name"`
+}
+
+// peopleCountEvent là giá trị bộ đếm người mới nhất của buổi học.
+type peopleCountEvent struct {
+	SnapshotID    string    `json:"snapshot_id"`
+	PeopleCounter int       `json:"people_counter"`
+	CapturedAt    time.Time `json:"captured_at"`
+}
+
+// feedCursor đọc con trỏ tiếp tục từ header Last-Event-ID (EventSource tự gửi khi kết nối lại)
+// hoặc tham số cursor. Không có con trỏ thì phát lại toàn bộ thay đổi của buổi học.
+func feedCursor(c echo.Context) (int64, error) {
+	value := c.Request().Header.Get("Last-Event-ID")
+	if value == "" {
+		value = c.QueryParam("cursor")
+	}
+	if value == "" {
+		return 0, nil
+	}
+	cursor, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || cursor < 0 {
+		return 0, fmt.Errorf("invalid cursor %q", value)
+	}
+	return cursor, nil
+}
+
+// loadFeedEvents lấy các thay đổi điểm danh của buổi học có seq lớn hơn cursor,
+// gồm cả bản ghi vừa bị chuyển sang buổi khác.
+func loadFeedEvents(scheduleID uuid.UUID, cursor int64, limit int) ([]attendanceFeedEvent, error) {
+	var events []attendanceFeedEvent
+	err := config.DB.Table("attendance_versions v").
+		Select("v.*, st.student_code, u.first_name, u.last_name").
+		Joins("LEFT JOIN students st ON st.student_id = v.student_id").
+		Joins("LEFT JOIN users u ON u.user_id = v.student_id").
+		Where("v.seq > ?", cursor).
+		Where("v.schedule_id = ? OR v.previous->>'schedule_id' = ?", scheduleID, scheduleID.String()).
+		Order("v.seq").
+		Limit(limit).
+		Scan(&events).Error
+	return events, err
+}
+
+// latestPeopleCount trả về snapshot bộ đếm người mới nhất của buổi học (nil nếu chưa có).
+func latestPeopleCount(scheduleID uuid.UUID) (*peopleCountEvent, error) {
+	var snapshots []peopleCountEvent
+	err := config.DB.Table("people_count_snapshots").
+		Select("snapshot_id, people_counter, captured_at").
+		Where("schedule_id = ?", scheduleID).
+		Order("captured_at DESC").
+		Limit(1).
+		Scan(&snapshots).Error
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	return &snapshots[0], nil
+}
+
+// writeSSE ghi một sự kiện Server-Sent Events và đẩy ngay xuống client.
+// id rỗng nghĩa là sự kiện không làm thay đổi con trỏ tiếp tục của client.
+func writeSSE(c echo.Context, id, event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	w := c.Response()
+	if id != "" {
+		if _, err := fmt.Fprintf(w, "id: %s\n", id); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// StreamAttendanceFeed đẩy realtime các thay đổi điểm danh của một buổi học qua Server-Sent Events:
+// mỗi lần bản ghi được tạo/cập nhật (camera, QR, sửa tay, ...) là một sự kiện "attendance" có id = seq,
+// và giá trị bộ đếm người mới nhất là sự kiện "people_count".
+// Client kết nối lại với Last-Event-ID (hoặc ?cursor=) để nhận tiếp từ sự kiện cuối cùng đã nhận.
+// Quyền xem giống các API điểm danh của buổi học; luồng đóng khi access token hết hạn.
+func StreamAttendanceFeed(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	cursor, err := feedCursor(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid cursor"})
+	}
+
+	var expiresAt time.Time
+	if claims, ok := c.Get("user").(jwt.MapClaims); ok {
+		if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+			expiresAt = exp.Time
+		}
+	}
+
+	pollInterval := utils.GetEnvDuration("ATTENDANCE_FEED_POLL_INTERVAL", 2*time.Second)
+	if pollInterval <= 0 {
+		pollInterval = 2 * time.Second
+	}
+	const heartbeatInterval = 15 * time.Second
+	const batchSize = 500
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // Tắt buffer của reverse proxy (nginx)
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", (3 * time.Second).Milliseconds()); err != nil {
+		return nil
+	}
+	w.Flush()
+
+	ctx := c.Request().Context()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	lastSnapshotID := ""
+
+	for {
+		// Gửi hết các thay đổi mới, theo từng lô
+		for {
+			events, err := loadFeedEvents(schedule.ScheduleID, cursor, batchSize)
+			if err != nil {
+				log.Printf("Error loading attendance feed for schedule %s: %v", schedule.ScheduleID, err)
+				return nil
+			}
+			versions := make([]models.AttendanceVersion, 0, len(events))
+			for _, event := range events {
+				if err := writeSSE(c, strconv.FormatInt(event.Seq, 10), "attendance", event); err != nil {
+					return nil
+				}
+				cursor = event.Seq
+				versions = append(versions, event.AttendanceVersion)
+				lastWrite = time.Now()
+			}
+			logVersionEvidenceAccess(c, versions)
+			if len(events) < batchSize {
+				break
+			}
+		}
+
+		count, err := latestPeopleCount(schedule.ScheduleID)
+		if err != nil {
+			log.Printf("Error loading people count for schedule %s: %v", schedule.ScheduleID, err)
+			return nil
+		}
+		if count != nil && count.SnapshotID != lastSnapshotID {
+			if err := writeSSE(c, "", "people_count", count); err != nil {
+				return nil
+			}
+			lastSnapshotID = count.SnapshotID
+			lastWrite = time.Now()
+		}
+
+		if time.Since(lastWrite) >= heartbeatInterval {
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+			lastWrite = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			if !expiresAt.IsZero() && now.After(expiresAt) {
+				_ = writeSSE(c, "", "token_expired", echo.Map{"cursor": cursor})
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// attendanceActor là người (hoặc hệ thống) thực hiện thay đổi điểm danh.
+type attendanceActor struct {
+	UserID *uuid.UUID
+	Role   string
+}
+
+// systemActor dùng cho các thay đổi do job nền thực hiện.
+var systemActor = attendanceActor{Role: "system"}
+
+// attendanceActorFrom lấy người thực hiện từ JWT claims của request.
+func attendanceActorFrom(c echo.Context) attendanceActor {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	actor := attendanceActor{Role: claimString(claims, "role")}
+	if id, err := uuid.Parse(claimString(claims, "user_id")); err == nil {
+		actor.UserID = &id
+	}
+	return actor
+}
+
+// recordAttendanceVersion lưu một phiên bản mới của bản ghi điểm danh. before = nil khi bản ghi vừa được tạo.
+// Phải gọi trong cùng transaction với thao tác ghi để lịch sử luôn khớp với dữ liệu.
+func recordAttendanceVersion(tx *gorm.DB, before *models.Attendance, after models.Attendance, source string, actor attendanceActor) error {
+	current, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	var previous json.RawMessage
+	if before != nil {
+		if previous, err = json.Marshal(before); err != nil {
+			return err
+		}
+	}
+
+	var version int
+	if err := tx.Model(&models.AttendanceVersion{}).
+		Where("attendance_id = ?", after.AttendanceID).
+		Select("COALESCE(MAX(version), 0) + 1").
+		Scan(&version).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AttendanceVersion{
+		AttendanceID: after.AttendanceID,
+		Version:      version,
+		ScheduleID:   after.ScheduleID,
+		StudentID:    after.StudentID,
+		Source:       source,
+		ActorID:      actor.UserID,
+		ActorRole:    actor.Role,
+		Previous:     previous,
+		Current:      current,
+	}).Error
+}
+
+// logVersionEvidenceAccess ghi log truy cập ảnh minh chứng xuất hiện trong lịch sử.
+func logVersionEvidenceAccess(c echo.Context, versions []models.AttendanceVersion) {
+	var accesses []biometricAccess
+	for _, v := range versions {
+		for _, snapshot := range []json.RawMessage{v.Previous, v.Current} {
+			var a models.Attendance
+			if len(snapshot) == 0 || json.Unmarshal(snapshot, &a) != nil {
+				continue
+			}
+			if a.EvidenceImageURL != nil && *a.EvidenceImageURL != "" {
+				accesses = append(accesses, biometricAccess{SubjectUserID: a.StudentID, ResourceID: a.AttendanceID.String()})
+				break
+			}
+		}
+	}
+	logBiometricAccess(c, "evidence_image", "read", accesses)
+}
+
+// GetAttendanceHistory trả về toàn bộ phiên bản của một bản ghi điểm danh.
+// Sinh viên xem được lịch sử của chính mình, giảng viên xem được lịch sử của lớp mình phụ trách.
+func GetAttendanceHistory(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+
+	var attendance models.Attendance
+	err := config.DB.First(&attendance, "attendance_id = ?", c.Param("attendance_id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Attendance record not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance"})
+	}
+	if attendance.StudentID.String() != claimString(claims, "user_id") {
+		var schedule models.Schedule
+		if err := config.DB.First(&schedule, "schedule_id = ?", attendance.ScheduleID).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve schedule"})
+		}
+		allowed, err := canReviewClass(claims, schedule.ClassID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check permission"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Attendance record not found"})
+		}
+	}
+
+	var versions []models.AttendanceVersion
+	if err := config.DB.Where("attendance_id = ?", attendance.AttendanceID).Order("version").Find(&versions).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance history"})
+	}
+	logVersionEvidenceAccess(c, versions)
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"attendance": attendance,
+		"versions":   versions,
+	})
+}
+
+// GetScheduleAttendanceHistory trả về lịch sử thay đổi điểm danh của cả một buổi học,
+// bao gồm cả các bản ghi đã bị chuyển sang buổi khác.
+func GetScheduleAttendanceHistory(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var versions []models.AttendanceVersion
+	err := config.DB.
+		Where("schedule_id = ? OR previous->>'schedule_id' = ?", schedule.ScheduleID, schedule.ScheduleID.String()).
+		Order("created_at, version").
+		Find(&versions).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance history"})
+	}
+	logVersionEvidenceAccess(c, versions)
+
+	return c.JSON(http.StatusOK, versions)
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errAttendanceFinalized được trả về khi ghi vào điểm danh của buổi học đã chốt.
+var errAttendanceFinalized = echo.NewHTTPError(http.StatusConflict, "Attendance for this session is finalized")
+
+// attendanceReviewWindow là số ngày rà soát sau khi buổi học kết thúc (ATTENDANCE_REVIEW_DAYS, mặc định 7).
+func attendanceReviewWindow() time.Duration {
+	days := utils.GetEnvInt("ATTENDANCE_REVIEW_DAYS", 7)
+	if days < 0 {
+		days = 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// attendanceState tính trạng thái chốt điểm danh của buổi học tại thời điểm now và hạn rà soát.
+// lock có thể rỗng (ScheduleID = uuid.Nil) nếu buổi học chưa có bản ghi chốt.
+func attendanceState(schedule models.Schedule, lock models.AttendanceLock, now time.Time) (string, time.Time) {
+	deadline := schedule.EndTime.Add(attendanceReviewWindow())
+	if lock.ReopenedUntil != nil && lock.ReopenedUntil.After(deadline) {
+		deadline = *lock.ReopenedUntil
+	}
+	switch {
+	case lock.FinalizedAt != nil:
+		return models.AttendanceStateFinalized, deadline
+	case now.Before(schedule.EndTime):
+		return models.AttendanceStateOpen, deadline
+	case now.Before(deadline):
+		return models.AttendanceStateUnderReview, deadline
+	}
+	return models.AttendanceStateFinalized, deadline
+}
+
+// ensureAttendanceEditable trả về errAttendanceFinalized nếu điểm danh của buổi học đã chốt.
+// Gọi trong transaction của thao tác ghi; bản ghi chốt được khoá chia sẻ để không bị chốt/mở khoá xen giữa.
+func ensureAttendanceEditable(tx *gorm.DB, scheduleID uuid.UUID) error {
+	var schedule models.Schedule
+	if err := tx.First(&schedule, "schedule_id = ?", scheduleID).Error; err != nil {
+		return err
+	}
+	var lock models.AttendanceLock
+	err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&lock, "schedule_id = ?", scheduleID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if state, _ := attendanceState(schedule, lock, time.Now()); state == models.AttendanceStateFinalized {
+		return errAttendanceFinalized
+	}
+	return nil
+}
+
+// finalizeDueAttendance chốt điểm danh của các buổi học đã hết thời gian rà soát.
+func finalizeDueAttendance(db *gorm.DB, now time.Time) (int64, error) {
+	var finalized int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var scheduleIDs []uuid.UUID
+		err := tx.Raw(`
+			INSERT INTO attendance_locks (schedule_id, finalized_at, updated_at)
+			SELECT s.schedule_id, ?, ?
+			FROM schedules s
+			LEFT JOIN attendance_locks l ON l.schedule_id = s.schedule_id
+			WHERE s.end_time <= ?
+				AND l.finalized_at IS NULL
+				AND (l.reopened_until IS NULL OR l.reopened_until <= ?)
+			ON CONFLICT (schedule_id) DO UPDATE
+				SET finalized_at = EXCLUDED.finalized_at, finalized_by = NULL, updated_at = EXCLUDED.updated_at
+			RETURNING schedule_id`,
+			now, now, now.Add(-attendanceReviewWindow()), now).
+			Scan(&scheduleIDs).Error
+		if err != nil {
+			return err
+		}
+		finalized = int64(len(scheduleIDs))
+		for _, id := range scheduleIDs {
+			if err := tx.Create(&models.AttendanceLockEvent{ScheduleID: id, Action: "finalized"}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return finalized, err
+}
+
+// StartAttendanceFinalizationJob chạy định kỳ việc chốt điểm danh sau thời gian rà soát
+// (ATTENDANCE_FINALIZE_INTERVAL, mặc định 1h, 0 để tắt).
+func StartAttendanceFinalizationJob() {
+	interval := utils.GetEnvDuration("ATTENDANCE_FINALIZE_INTERVAL", time.Hour)
+	if interval <= 0 {
+		log.Println("Attendance finalization job disabled")
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			finalized, err := finalizeDueAttendance(config.DB, time.Now())
+			if err != nil {
+				log.Printf("Attendance finalization job failed: %v", err)
+			} else if finalized > 0 {
+				log.Printf("Attendance finalization job finalized %d sessions", finalized)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// attendanceStateResponse trả về trạng thái chốt điểm danh kèm lịch sử chốt/mở khoá.
+func attendanceStateResponse(c echo.Context, schedule models.Schedule) error {
+	var lock models.AttendanceLock
+	if err := config.DB.First(&lock, "schedule_id = ?", schedule.ScheduleID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance state"})
+	}
+	var events []models.AttendanceLockEvent
+	if err := config.DB.Where("schedule_id = ?", schedule.ScheduleID).Order("created_at").Find(&events).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance state"})
+	}
+
+	state, deadline := attendanceState(schedule, lock, time.Now())
+	return c.JSON(http.StatusOK, echo.Map{
+		"schedule_id":     schedule.ScheduleID,
+		"state":           state,
+		"review_deadline": deadline,
+		"finalized_at":    lock.FinalizedAt,
+		"events":          events,
+	})
+}
+
+// GetAttendanceState trả về trạng thái điểm danh của buổi học: open, under_review hoặc finalized.
+func GetAttendanceState(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	return attendanceStateResponse(c, schedule)
+}
+
+// FinalizeAttendance cho phép giảng viên chốt điểm danh sớm khi buổi học đã kết thúc.
+func FinalizeAttendance(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	now := time.Now()
+	if now.Before(schedule.EndTime) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "The session has not ended yet"})
+	}
+
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	actorID, _ := uuid.Parse(claimString(claims, "user_id"))
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var lock models.AttendanceLock
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lock, "schedule_id = ?", schedule.ScheduleID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if lock.FinalizedAt != nil {
+			return errAttendanceFinalized
+		}
+		lock.ScheduleID = schedule.ScheduleID
+		lock.FinalizedAt = &now
+		lock.FinalizedBy = &actorID
+		if err := tx.Save(&lock).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AttendanceLockEvent{ScheduleID: schedule.ScheduleID, Action: "finalized", ActorID: &actorID}).Error
+	})
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return c.JSON(he.Code, echo.Map{"error": he.Message})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to finalize attendance"})
+	}
+	return attendanceStateResponse(c, schedule)
+}
+
+// UnlockAttendance cho phép admin mở khoá điểm danh đã chốt (bắt buộc có lý do).
+// Buổi học quay lại trạng thái rà soát thêm một khoảng ATTENDANCE_REVIEW_DAYS rồi được chốt lại.
+func UnlockAttendance(c echo.Context) error {
+	var schedule models.Schedule
+	if err := config.DB.First(&schedule, "schedule_id = ?", c.Param("schedule_id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Schedule not found"})
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	input.Reason = strings.TrimSpace(input.Reason)
+	if input.Reason == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "reason is required"})
+	}
+
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	actorID, _ := uuid.Parse(claimString(claims, "user_id"))
+	now := time.Now()
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var lock models.AttendanceLock
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&lock, "schedule_id = ?", schedule.ScheduleID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if state, _ := attendanceState(schedule, lock, now); state != models.AttendanceStateFinalized {
+			return echo.NewHTTPError(http.StatusConflict, "Attendance for this session is not finalized")
+		}
+		reopenedUntil := now.Add(attendanceReviewWindow())
+		lock.ScheduleID = schedule.ScheduleID
+		lock.FinalizedAt = nil
+		lock.FinalizedBy = nil
+		lock.ReopenedUntil = &reopenedUntil
+		if err := tx.Save(&lock).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.AttendanceLockEvent{
+			ScheduleID: schedule.ScheduleID,
+			Action:     "unlocked",
+			ActorID:    &actorID,
+			Reason:     &input.Reason,
+		}).Error
+	})
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		return c.JSON(he.Code, echo.Map{"error": he.Message})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to unlock attendance"})
+	}
+	recordSecurityEvent(nil, &actorID, "attendance_unlocked", c.RealIP(), schedule.ScheduleID.String()+": "+input.Reason)
+
+	return attendanceStateResponse(c, schedule)
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// effectiveAttendancePolicy trả về chính sách áp dụng cho một lớp: ưu tiên chính sách riêng của lớp,
+// sau đó tới chính sách của khoá học, cuối cùng là mặc định (không có thời gian ân hạn).
+// source là "class", "course" hoặc "default".
+func effectiveAttendancePolicy(db *gorm.DB, classID uuid.UUID) (policy models.AttendancePolicy, source string, err error) {
+	err = db.Raw(`
+		SELECT p.*
+		FROM attendance_policies p
+		JOIN classes c ON p.class_id = c.class_id OR (p.class_id IS NULL AND p.course_id = c.course_id)
+		WHERE c.class_id = ?
+		ORDER BY p.class_id NULLS LAST
+		LIMIT 1`, classID).Scan(&policy).Error
+	if err != nil {
+		return policy, "", err
+	}
+	switch {
+	case policy.PolicyID == uuid.Nil:
+		return models.AttendancePolicy{}, "default", nil
+	case policy.ClassID != nil:
+		return policy, "class", nil
+	default:
+		return policy, "course", nil
+	}
+}
+
+// attendanceStatusFor xác định trạng thái điểm danh theo chính sách dựa trên thời điểm có mặt
+// so với giờ bắt đầu buổi học.
+func attendanceStatusFor(policy models.AttendancePolicy, schedule models.Schedule, at time.Time) string {
+	delay := at.Sub(schedule.StartTime)
+	if policy.AbsentAfterMinutes > 0 && delay > time.Duration(policy.AbsentAfterMinutes)*time.Minute {
+		return models.AttendanceStatusAbsent
+	}
+	if delay > time.Duration(policy.GraceMinutes)*time.Minute {
+		return models.AttendanceStatusLate
+	}
+	return models.AttendanceStatusPresent
+}
+
+// countStatusSQL sinh biểu thức SQL đếm số bản ghi có trạng thái status trong bảng alias.
+func countStatusSQL(alias, status string) string {
+	return fmt.Sprintf("COUNT(*) FILTER (WHERE %s.status = '%s')", alias, status)
+}
+
+// policyJoinSQL nối chính sách áp dụng (alias pol) cho lớp có alias classAlias.
+func policyJoinSQL(classAlias string) string {
+	return fmt.Sprintf(`LEFT JOIN LATERAL (
+			SELECT p.late_absence_weight, p.warning_absence_percent, p.max_absence_percent
+			FROM attendance_policies p
+			WHERE p.class_id = %[1]s.class_id OR (p.class_id IS NULL AND p.course_id = %[1]s.course_id)
+			ORDER BY p.class_id NULLS LAST
+			LIMIT 1
+		) pol ON true`, classAlias)
+}
+
+// absenceUnitsSQL sinh biểu thức SQL tính số buổi vắng quy đổi: vắng tính 1, đi muộn tính theo
+// late_absence_weight của chính sách (cần policyJoinSQL trong câu truy vấn).
+func absenceUnitsSQL(alias string) string {
+	return fmt.Sprintf(`COALESCE(SUM(CASE
+			WHEN %[1]s.status = '%[2]s' THEN 1
+			WHEN %[1]s.status = '%[3]s' THEN COALESCE(pol.late_absence_weight, 0)
+			ELSE 0 END), 0)`, alias, models.AttendanceStatusAbsent, models.AttendanceStatusLate)
+}
+
+// policyScope xác định chính sách đang thao tác thuộc khoá học hay lớp dựa trên tham số URL
+// và kiểm tra giảng viên có phụ trách khoá học/lớp đó hay không.
+func policyScope(c echo.Context) (courseID, classID *uuid.UUID, httpErr *echo.HTTPError) {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	role := claimString(claims, "role")
+	userID := claimString(claims, "user_id")
+
+	var count int64
+	if raw := c.Param("course_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid course_id")
+		}
+		query := config.DB.Model(&models.Course{}).Where("course_id = ?", id)
+		if role != "admin" {
+			query = query.Where("main_lecturer_id = ?", userID)
+		}
+		if err := query.Count(&count).Error; err != nil {
+			return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve course")
+		}
+		if count == 0 {
+			return nil, nil, echo.NewHTTPError(http.StatusNotFound, "Course not found")
+		}
+		return &id, nil, nil
+	}
+
+	id, err := uuid.Parse(c.Param("class_id"))
+	if err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid class_id")
+	}
+	query := config.DB.Model(&models.Class{}).Where("class_id = ?", id)
+	if role != "admin" {
+		query = query.Where("lecturer_id = ?", userID)
+	}
+	if err := query.Count(&count).Error; err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve class")
+	}
+	if count == 0 {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "Class not found")
+	}
+	return nil, &id, nil
+}
+
+// GetAttendancePolicy trả về chính sách của khoá học, hoặc chính sách đang áp dụng cho lớp
+// (kèm nguồn: class, course hoặc default).
+func GetAttendancePolicy(c echo.Context) error {
+	courseID, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+	}
+
+	if classID != nil {
+		policy, source, err := effectiveAttendancePolicy(config.DB, *classID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve attendance policy"})
+		}
+		return c.JSON(http.StatusOK, echo.Map{"source": source, "policy": policy})
+	}
+
+	var policy models.AttendancePolicy
+	err := config.DB.Where("course_id = ?", courseID).First(&policy).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusOK, echo.Map{"source": "default", "policy": models.AttendancePolicy{}})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve attendance policy"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"source": "course", "policy": policy})
+}
+
+// UpdateAttendancePolicy tạo hoặc cập nhật chính sách của khoá học / lớp.
+func UpdateAttendancePolicy(c echo.Context) error {
+	courseID, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+	}
+
+	var input struct {
+		GraceMinutes       int     `json:"grace_minutes"`
+		AbsentAfterMinutes int     `json:"absent_after_minutes"`
+		LateAbsenceWeight  float64 `json:"late_absence_weight"`
+		// Ngưỡng xét dự thi (%), 0 = mặc định
+		WarningAbsencePercent float64 `json:"warning_absence_percent"`
+		MaxAbsencePercent     float64 `json:"max_absence_percent"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+	if input.GraceMinutes < 0 || input.AbsentAfterMinutes < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Minutes must not be negative"})
+	}
+	if input.AbsentAfterMinutes > 0 && input.AbsentAfterMinutes <= input.GraceMinutes {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "absent_after_minutes must be greater than grace_minutes"})
+	}
+	if input.LateAbsenceWeight < 0 || input.LateAbsenceWeight > 1 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "late_absence_weight must be between 0 and 1"})
+	}
+	if input.WarningAbsencePercent < 0 || input.WarningAbsencePercent > 100 || input.MaxAbsencePercent < 0 || input.MaxAbsencePercent > 100 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Absence percentages must be between 0 and 100"})
+	}
+	if input.WarningAbsencePercent > 0 && input.MaxAbsencePercent > 0 && input.WarningAbsencePercent > input.MaxAbsencePercent {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "warning_absence_percent must not exceed max_absence_percent"})
+	}
+
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var policy models.AttendancePolicy
+	query := config.DB.Where("course_id = ?", courseID)
+	if classID != nil {
+		query = config.DB.Where("class_id = ?", classID)
+	}
+	if err := query.First(&policy).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve attendance policy"})
+	}
+
+	policy.CourseID = courseID
+	policy.ClassID = classID
+	policy.GraceMinutes = input.GraceMinutes
+	policy.AbsentAfterMinutes = input.AbsentAfterMinutes
+	policy.LateAbsenceWeight = input.LateAbsenceWeight
+	policy.WarningAbsencePercent = input.WarningAbsencePercent
+	policy.MaxAbsencePercent = input.MaxAbsencePercent
+	policy.UpdatedBy = &user.UserID
+	if policy.PolicyID == uuid.Nil {
+		policy.PolicyID = uuid.New()
+	}
+	if err := config.DB.Save(&policy).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update attendance policy"})
+	}
+
+	return c.JSON(http.StatusOK, policy)
+}
+
+// DeleteAttendancePolicy xoá chính sách; lớp sẽ quay về dùng chính sách của khoá học.
+func DeleteAttendancePolicy(c echo.Context) error {
+	courseID, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+	}
+
+	query := config.DB.Where("course_id = ?", courseID)
+	if classID != nil {
+		query = config.DB.Where("class_id = ?", classID)
+	}
+	if err := query.Delete(&models.AttendancePolicy{}).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to delete attendance policy"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Attendance policy deleted"})
+}
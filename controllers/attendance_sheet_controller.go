@@ -0,0 +1,166 @@
+package controllers
+
+import (
+	"bytes"
+	"cms-backend/config"
+	"cms-backend/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// Độ rộng cột (mm) của danh sách ký tên trên khổ A4 dọc (vùng in 186mm)
+var attendanceSheetColumns = []utils.ExportColumn{
+	{Title: "STT", Width: 12},
+	{Title: "Mã SV", Width: 28},
+	{Title: "Họ và tên", Width: 66},
+	{Title: "Ký tên", Width: 45},
+	{Title: "Ghi chú", Width: 35},
+}
+
+const attendanceSheetRowHeight = 9.0
+
+// scheduleRecordURL là đường dẫn tới bản ghi của buổi học được in trong mã QR: SCHEDULE_RECORD_URL/<schedule_id>.
+// Bắt buộc cấu hình: địa chỉ suy ra từ request có thể là địa chỉ nội bộ sau reverse proxy.
+func scheduleRecordURL(scheduleID uuid.UUID) (string, error) {
+	base := os.Getenv("SCHEDULE_RECORD_URL")
+	if base == "" {
+		return "", errors.New("SCHEDULE_RECORD_URL is not configured")
+	}
+	return strings.TrimRight(base, "/") + "/" + scheduleID.String(), nil
+}
+
+// GetAttendanceSheet tạo file PDF danh sách ký tên điểm danh của một buổi học, dùng cho phòng học không có camera.
+// Danh sách gồm sinh viên đang học của lớp (class_students), kèm mã QR dẫn tới bản ghi của buổi học.
+func GetAttendanceSheet(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	loc, err := reportLocation(c.QueryParam("tz"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid tz"})
+	}
+	recordURL, err := scheduleRecordURL(schedule.ScheduleID)
+	if err != nil {
+		log.Printf("Error generating attendance sheet: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Attendance sheet link is not configured"})
+	}
+
+	var header struct {
+		ClassName    string
+		CourseName   string
+		RoomName     string
+		LecturerName string
+	}
+	if err := config.DB.Raw(`
+		SELECT c.class_name, co.course_name, r.room_name,
+			u.first_name || ' ' || u.last_name AS lecturer_name
+		FROM classes c
+		JOIN courses co ON co.course_id = c.course_id
+		LEFT JOIN classrooms r ON r.classroom_id = ?
+		LEFT JOIN users u ON u.user_id = c.lecturer_id
+		WHERE c.class_id = ?`, schedule.ClassroomID, schedule.ClassID).Scan(&header).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve class"})
+	}
+
+	var students []struct {
+		StudentCode string
+		FullName    string
+	}
+	if err := config.DB.Raw(`
+		SELECT s.student_code, u.first_name || ' ' || u.last_name AS full_name
+		FROM class_students cs
+		JOIN students s ON s.student_id = cs.student_id
+		JOIN users u ON u.user_id = cs.student_id
+		WHERE cs.class_id = ? AND COALESCE(cs.status, '') NOT IN ?
+		ORDER BY s.student_code`, schedule.ClassID, inactiveClassStudentStatuses).Scan(&students).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve students"})
+	}
+
+	pdf := utils.NewPDF("P")
+	pdf.AddPage()
+	left, top, right, bottom := pdf.GetMargins()
+	pageWidth, pageHeight := pdf.GetPageSize()
+
+	// Mã QR ở góc phải, thông tin buổi học bên trái
+	const qrSize = 30.0
+	if err := utils.PDFQRCode(pdf, recordURL, pageWidth-right-qrSize, top, qrSize); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to generate QR code"})
+	}
+	textWidth := pageWidth - left - right - qrSize - 4
+	pdf.SetFont(utils.PDFFont, "B", 14)
+	pdf.MultiCell(textWidth, 7, utils.PDFText("DANH SÁCH ĐIỂM DANH"), "", "L", false)
+	pdf.SetFont(utils.PDFFont, "", 10)
+	for _, line := range [][2]string{
+		{"Học phần", header.CourseName},
+		{"Lớp", header.ClassName},
+		{"Giảng viên", header.LecturerName},
+		{"Phòng", header.RoomName},
+		{"Thời gian", schedule.StartTime.In(loc).Format("02/01/2006 15:04") + " - " + schedule.EndTime.In(loc).Format("15:04")},
+		{"Nội dung", schedule.Topic},
+	} {
+		if line[1] == "" {
+			continue
+		}
+		pdf.MultiCell(textWidth, 5.5, utils.PDFText(line[0]+": "+line[1]), "", "L", false)
+	}
+	if y := top + qrSize + 4; pdf.GetY() < y {
+		pdf.SetY(y)
+	}
+
+	drawHeader := func() {
+		pdf.SetFont(utils.PDFFont, "B", 10)
+		pdf.SetFillColor(230, 230, 230)
+		for _, col := range attendanceSheetColumns {
+			pdf.CellFormat(col.Width, attendanceSheetRowHeight-1, utils.PDFText(col.Title), "1", 0, "C", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont(utils.PDFFont, "", 10)
+	}
+	drawHeader()
+	for i, s := range students {
+		if pdf.GetY()+attendanceSheetRowHeight > pageHeight-bottom {
+			pdf.AddPage()
+			drawHeader()
+		}
+		values := []string{fmt.Sprint(i + 1), s.StudentCode, s.FullName, "", ""}
+		for j, col := range attendanceSheetColumns {
+			align := "L"
+			if j == 0 {
+				align = "C"
+			}
+			pdf.CellFormat(col.Width, attendanceSheetRowHeight, utils.FitPDFText(pdf, values[j], col.Width-2), "1", 0, align, false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	// Phần tổng kết và chữ ký giảng viên không bị tách sang trang khác
+	if pdf.GetY()+35 > pageHeight-bottom {
+		pdf.AddPage()
+	}
+	pdf.Ln(4)
+	pdf.CellFormat(0, 6, utils.PDFText(fmt.Sprintf("Sĩ số: %d        Có mặt: ......        Vắng: ......", len(students))), "", 1, "L", false, 0, "")
+	pdf.Ln(4)
+	signX := pageWidth - right - 70
+	pdf.SetX(signX)
+	pdf.SetFont(utils.PDFFont, "B", 10)
+	pdf.CellFormat(70, 6, utils.PDFText("Giảng viên"), "", 1, "C", false, 0, "")
+	pdf.SetFont(utils.PDFFont, "", 9)
+	pdf.SetX(signX)
+	pdf.CellFormat(70, 5, utils.PDFText("(Ký và ghi rõ họ tên)"), "", 1, "C", false, 0, "")
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to generate attendance sheet"})
+	}
+	filename := fmt.Sprintf("attendance-sheet-%s-%s.pdf", schedule.StartTime.In(loc).Format("20060102-1504"), schedule.ScheduleID.String()[:8])
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	return c.Blob(http.StatusOK, utils.ExportContentType(utils.ExportPDF), buf.Bytes())
+}
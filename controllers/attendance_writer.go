@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"cms-backend/models"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// lockAttendance khoá điểm danh của các buổi học trong transaction hiện tại để các luồng ghi
+// đồng thời (camera, QR, ...) không tạo bản ghi trùng. Khoá theo buổi học còn bảo đảm seq của
+// lịch sử thay đổi tăng đúng theo thứ tự commit trong mỗi buổi, để luồng realtime không bỏ sót sự kiện.
+// Nhiều buổi học được khoá theo thứ tự cố định để tránh deadlock.
+func lockAttendance(tx *gorm.DB, scheduleIDs ...uuid.UUID) error {
+	keys := make([]string, 0, len(scheduleIDs))
+	for _, id := range scheduleIDs {
+		keys = append(keys, id.String())
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i > 0 && key == keys[i-1] {
+			continue
+		}
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "attendance:"+key).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordArrival tạo hoặc cập nhật bản ghi điểm danh khi sinh viên được ghi nhận có mặt.
+// Trạng thái được tính theo chính sách điểm danh của lớp. Thời điểm có mặt sớm nhất được giữ lại;
+// bản ghi "absent" được chuyển thành có mặt nếu lần ghi nhận mới nằm trong giờ cho phép.
+// Mỗi thay đổi được lưu thành một phiên bản trong lịch sử.
+// Trả về bản ghi sau khi ghi và cho biết bản ghi có thay đổi hay không.
+func recordArrival(tx *gorm.DB, schedule models.Schedule, studentID uuid.UUID, at time.Time, evidenceURL *string, channel string, actor attendanceActor) (models.Attendance, bool, error) {
+	if err := lockAttendance(tx, schedule.ScheduleID); err != nil {
+		return models.Attendance{}, false, err
+	}
+	if err := ensureAttendanceEditable(tx, schedule.ScheduleID); err != nil {
+		return models.Attendance{}, false, err
+	}
+
+	policy, _, err := effectiveAttendancePolicy(tx, schedule.ClassID)
+	if err != nil {
+		return models.Attendance{}, false, err
+	}
+	status := attendanceStatusFor(policy, schedule, at)
+
+	var attendance models.Attendance
+	err = tx.Where("schedule_id = ? AND student_id = ?", schedule.ScheduleID, studentID).First(&attendance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		attendance = models.Attendance{
+			AttendanceID:     uuid.New(),
+			ScheduleID:       schedule.ScheduleID,
+			StudentID:        studentID,
+			AttendanceTime:   at,
+			Status:           status,
+			EvidenceImageURL: evidenceURL,
+			Channel:          &channel,
+		}
+		if err := tx.Create(&attendance).Error; err != nil {
+			return attendance, false, err
+		}
+		return attendance, true, recordAttendanceVersion(tx, nil, attendance, channel, actor)
+	}
+	if err != nil {
+		return attendance, false, err
+	}
+	before := attendance
+
+	// Chỉ ghi đè khi đây là lần có mặt sớm hơn, hoặc khi chuyển bản ghi vắng (kể cả có phép) thành có mặt
+	notArrived := attendance.Status == models.AttendanceStatusAbsent || attendance.Status == models.AttendanceStatusExcused
+	upgrade := notArrived && status != models.AttendanceStatusAbsent
+	if !upgrade && (notArrived || !at.Before(attendance.AttendanceTime)) {
+		if attendance.EvidenceImageURL != nil || evidenceURL == nil {
+			return attendance, false, nil
+		}
+		attendance.EvidenceImageURL = evidenceURL
+		if err := tx.Model(&attendance).Update("evidence_image_url", evidenceURL).Error; err != nil {
+			return attendance, false, err
+		}
+		return attendance, true, recordAttendanceVersion(tx, &before, attendance, channel, actor)
+	}
+
+	attendance.AttendanceTime = at
+	attendance.Status = status
+	attendance.Channel = &channel
+	if evidenceURL != nil {
+		attendance.EvidenceImageURL = evidenceURL
+	}
+	err = tx.Model(&attendance).Updates(map[string]interface{}{
+		"attendance_time":    attendance.AttendanceTime,
+		"status":             attendance.Status,
+		"evidence_image_url": attendance.EvidenceImageURL,
+		"channel":            channel,
+	}).Error
+	if err != nil {
+		return attendance, false, err
+	}
+	return attendance, true, recordAttendanceVersion(tx, &before, attendance, channel, actor)
+}
+
+// recordExcused đánh dấu sinh viên vắng có phép ở một buổi học. Bản ghi có mặt/đi muộn được giữ nguyên
+// vì sinh viên thực tế đã tham gia. Trả về true nếu bản ghi được tạo hoặc thay đổi.
+func recordExcused(tx *gorm.DB, schedule models.Schedule, studentID uuid.UUID, note string, actor attendanceActor) (bool, error) {
+	if err := lockAttendance(tx, schedule.ScheduleID); err != nil {
+		return false, err
+	}
+	if err := ensureAttendanceEditable(tx, schedule.ScheduleID); err != nil {
+		return false, err
+	}
+
+	channel := models.AttendanceChannelLeave
+	var attendance models.Attendance
+	err := tx.Where("schedule_id = ? AND student_id = ?", schedule.ScheduleID, studentID).First(&attendance).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		attendance = models.Attendance{
+			AttendanceID:   uuid.New(),
+			ScheduleID:     schedule.ScheduleID,
+			StudentID:      studentID,
+			AttendanceTime: schedule.StartTime,
+			Status:         models.AttendanceStatusExcused,
+			Note:           &note,
+			Channel:        &channel,
+		}
+		if err := tx.Create(&attendance).Error; err != nil {
+			return false, err
+		}
+		return true, recordAttendanceVersion(tx, nil, attendance, channel, actor)
+	}
+	if err != nil {
+		return false, err
+	}
+	if attendance.Status != models.AttendanceStatusAbsent {
+		return false, nil
+	}
+
+	before := attendance
+	attendance.Status = models.AttendanceStatusExcused
+	attendance.Note = &note
+	attendance.Channel = &channel
+	if err := tx.Model(&attendance).Updates(map[string]interface{}{
+		"status":  attendance.Status,
+		"note":    note,
+		"channel": channel,
+	}).Error; err != nil {
+		return false, err
+	}
+	return true, recordAttendanceVersion(tx, &before, attendance, channel, actor)
+}
@@ -3,10 +3,11 @@ package controllers
 import (
 	"cms-backend/config"
 	"cms-backend/models"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
-	"strings"
 	"time"
 
 	"cms-backend/utils"
@@ -14,27 +15,40 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	pgvector "github.com/pgvector/pgvector-go"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+const resetTokenTTL = 15 * time.Minute
+
+// GetJWKS công bố các khoá công khai để các dịch vụ khác (Kong, camera AI) tự xác thực token.
+func GetJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{
+		"keys": utils.DefaultSigner().JWKS(),
+	})
+}
 
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(bytes), err
 }
 
-func GenerateFakeEmbedding(dim int) pgvector.Vector {
-	vec := pgvector.NewVector(make([]float32, dim))
-	return vec
-}
-
 func RegisterUser(c echo.Context) error {
-	var user models.User
-	if err := c.Bind(&user); err != nil {
+	// password_hash không còn được bind vào models.User (json:"-") nên nhận mật khẩu qua input riêng;
+	// vẫn chấp nhận khoá "password_hash" cũ để tương thích với client hiện tại
+	var input struct {
+		models.User
+		Password       string `json:"password"`
+		LegacyPassword string `json:"password_hash"`
+	}
+	if err := c.Bind(&input); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
 	}
+	user := input.User
+	user.PasswordHash = input.Password
+	if user.PasswordHash == "" {
+		user.PasswordHash = input.LegacyPassword
+	}
 
 	var existing models.User
 	if err := config.DB.Where("username = ? OR email = ?", user.Username, user.Email).First(&existing).Error; err == nil {
@@ -61,10 +75,10 @@ func RegisterUser(c echo.Context) error {
 				break
 			}
 		}
+		// Khuôn mặt được đăng ký sau qua /users/:user_id/face-embeddings
 		s := models.Student{
-			StudentID:     user.UserID,
-			StudentCode:   studentCode,
-			FaceEmbedding: GenerateFakeEmbedding(512)}
+			StudentID:   user.UserID,
+			StudentCode: studentCode}
 		config.DB.Create(&s)
 	case "lecturer":
 		l := models.Lecturer{LecturerID: user.UserID, LectainerCode: uuid.New().String()}
@@ -72,6 +86,8 @@ func RegisterUser(c echo.Context) error {
 	case "admin":
 		a := models.Admin{AdminID: user.UserID, AdminCode: uuid.New().String()}
 		config.DB.Create(&a)
+	case "service":
+		// Tài khoản máy (dịch vụ camera AI), không có hồ sơ riêng
 	default:
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid role"})
 	}
@@ -90,29 +106,54 @@ func LoginUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid input"})
 	}
 
+	ip := c.RealIP()
+
 	var user models.User
 	if err := config.DB.Where("username = ? OR email = ?", input.UsernameOrEmail, input.UsernameOrEmail).First(&user).Error; err != nil {
+		// Vẫn kiểm tra giới hạn theo IP và chạy bcrypt để không lộ tài khoản nào tồn tại
+		if wait, locked, err := loginRetryAfter(ipThrottleKey(ip)); err == nil && wait > 0 {
+			return tooManyAttempts(c, wait, locked)
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(input.Password))
+		recordLoginFailure(nil, ip)
 		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid credentials"})
 	}
 
+	// Chặn trước khi so sánh bcrypt nếu tài khoản hoặc IP đang bị backoff/khoá
+	wait, locked, err := loginRetryAfter(ipThrottleKey(ip), accountThrottleKey(user.UserID))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check login attempts"})
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait, locked)
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
+		recordLoginFailure(&user, ip)
 		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid credentials"})
 	}
-	accessToken, err := utils.GenerateAccessToken(user)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate access token"})
-	}
+	resetLoginFailures(user.UserID)
 
-	refreshToken, err := utils.GenerateRefreshToken(user)
+	// Tài khoản đã bật 2FA, hoặc role bắt buộc 2FA, chỉ nhận token mfa_pending
+	// cho đến khi xác minh (hoặc đăng ký) mã TOTP
+	mfaEnabled, mfaRequired, err := mfaStatus(user)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate refresh token"})
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check MFA status"})
+	}
+	if mfaEnabled || mfaRequired {
+		mfaToken, err := utils.GenerateMFAPendingToken(user)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate MFA token"})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"message":                 "MFA verification required",
+			"mfa_required":            true,
+			"mfa_enrollment_required": !mfaEnabled,
+			"mfa_token":               mfaToken,
+		})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":       "Login successful",
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-	})
+	return issueLoginTokens(c, user, nil)
 }
 func ChangePassword(c echo.Context) error {
 	claims := c.Get("user").(jwt.MapClaims)
@@ -141,7 +182,17 @@ func ChangePassword(c echo.Context) error {
 	}
 
 	user.PasswordHash = hashed
-	config.DB.Save(&user)
+	if err := config.DB.Save(&user).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to change password"})
+	}
+
+	// Đổi mật khẩu sẽ vô hiệu hoá reset token và đăng xuất mọi phiên đăng nhập khác
+	if err := invalidateResetTokens(config.DB, user.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to invalidate reset tokens"})
+	}
+	if err := revokeUserRefreshTokens(user.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to revoke sessions"})
+	}
 
 	return c.JSON(http.StatusOK, map[string]string{"message": "Password changed successfully"})
 }
@@ -154,72 +205,135 @@ func ForgotPassword(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid email"})
 	}
 
+	// Luôn trả về cùng một thông báo để không lộ email nào đã được đăng ký
+	response := map[string]string{"message": "If the email exists, a reset link has been sent"}
+
 	var user models.User
 	if err := config.DB.First(&user, "email = ?", input.Email).Error; err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+		return c.JSON(http.StatusOK, response)
 	}
 
-	// Normally here you would generate a reset token and send it via email
-	// For demo, we just return a fake token (insecure in real app)
-	resetToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.UserID.String(),
-		"exp":     jwt.NewNumericDate(time.Now().Add(15 * time.Minute)), // 15 minutes expiry
-	})
-	tokenStr, err := resetToken.SignedString(jwtSecret)
+	token, err := utils.GenerateRandomToken(32)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate reset token"})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"message":     "Reset token generated (normally sent via email)",
-		"reset_token": tokenStr,
-	})
+	// Mỗi user chỉ có một reset token còn hiệu lực tại một thời điểm
+	if err := invalidateResetTokens(config.DB, user.UserID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate reset token"})
+	}
+	record := models.PasswordResetToken{
+		TokenID:   uuid.New(),
+		UserID:    user.UserID,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	}
+	if err := config.DB.Create(&record).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate reset token"})
+	}
+
+	link := os.Getenv("RESET_PASSWORD_URL") + "?token=" + token
+	body := fmt.Sprintf("Xin chào %s %s,\n\nĐể đặt lại mật khẩu, vui lòng truy cập liên kết sau (hết hạn sau %d phút):\n%s\n\nNếu bạn không yêu cầu đặt lại mật khẩu, hãy bỏ qua email này.",
+		user.FirstName, user.LastName, int(resetTokenTTL.Minutes()), link)
+
+	// Gửi email bất đồng bộ để thời gian phản hồi không phụ thuộc vào việc email có tồn tại hay không
+	go func(to string) {
+		if err := config.Mailer.Send(to, "Đặt lại mật khẩu", body); err != nil {
+			log.Printf("Error sending reset password email: %v", err)
+		}
+	}(user.Email)
+
+	return c.JSON(http.StatusOK, response)
 }
-func RefreshToken(c echo.Context) error {
-	// Lấy refresh_token từ header Authorization
-	authHeader := c.Request().Header.Get("Authorization")
-	fmt.Println("Authorization header:", authHeader)
 
-	if authHeader == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Missing Authorization header"})
+func ResetPassword(c echo.Context) error {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.Bind(&input); err != nil || input.Token == "" || input.NewPassword == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
 	}
 
-	// Kiểm tra xem header có chứa Bearer token không
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid Authorization header format"})
+	hashed, err := HashPassword(input.NewPassword)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to hash password"})
 	}
 
-	// Lấy refresh_token từ Authorization header
-	refreshToken := strings.TrimPrefix(authHeader, "Bearer ")
+	var userID uuid.UUID
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var record models.PasswordResetToken
+		if err := tx.First(&record, "token_hash = ? AND used_at IS NULL AND expires_at > ?",
+			utils.HashToken(input.Token), time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Đánh dấu đã dùng; nếu request khác đã dùng token trước thì RowsAffected = 0
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("token_id = ? AND used_at IS NULL", record.TokenID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
 
-	// Kiểm tra và xử lý refresh_token
-	token, err := jwt.Parse(refreshToken, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+		userID = record.UserID
+		return tx.Model(&models.User{}).Where("user_id = ?", userID).Update("password_hash", hashed).Error
 	})
-	if err != nil || !token.Valid {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid or expired reset token"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to reset password"})
 	}
 
-	// Tiến hành xử lý khi token hợp lệ
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok || claims["user_id"] == nil || claims["role"] == nil {
-		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid token claims"})
+	// Mật khẩu đã đổi: vô hiệu hoá các reset token còn lại và đăng xuất mọi phiên
+	if err := invalidateResetTokens(config.DB, userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to invalidate reset tokens"})
+	}
+	if err := revokeUserRefreshTokens(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to revoke sessions"})
 	}
 
-	// Tạo access_token mới
-	newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": claims["user_id"],
-		"role":    claims["role"],
-		"exp":     time.Now().Add(15 * time.Minute).Unix(),
-	})
+	return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
+}
 
-	tokenStr, err := newAccessToken.SignedString(jwtSecret)
+// invalidateResetTokens vô hiệu hoá mọi reset token chưa dùng của user.
+func invalidateResetTokens(tx *gorm.DB, userID uuid.UUID) error {
+	return tx.Model(&models.PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
+
+func RefreshToken(c echo.Context) error {
+	// Lấy refresh_token từ header Authorization
+	refreshToken, ok := bearerToken(c)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid Authorization header format"})
+	}
+
+	// Chỉ chấp nhận token có typ = refresh, access token không thể dùng để làm mới
+	if _, err := utils.ParseToken(refreshToken, utils.TokenTypeRefresh); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+	}
+
+	// Xoay vòng refresh token: token cũ bị thu hồi, trả về cặp token mới
+	accessToken, newRefreshToken, err := rotateRefreshToken(refreshToken)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate new access token"})
+		if errors.Is(err, errRefreshTokenReused) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Refresh token reuse detected, session revoked"})
+		}
+		if errors.Is(err, errRefreshTokenExpired) || errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to refresh token"})
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
-		"access_token": tokenStr,
+		"access_token":  accessToken,
+		"refresh_token": newRefreshToken,
 	})
 }
 
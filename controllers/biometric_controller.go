@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// biometricAccess mô tả một tài nguyên sinh trắc học được đọc trong một request.
+type biometricAccess struct {
+	SubjectUserID uuid.UUID
+	ResourceID    string
+}
+
+// logBiometricAccess ghi log truy cập dữ liệu sinh trắc học của người gọi hiện tại.
+// Lỗi chỉ được ghi log để không chặn việc trả dữ liệu.
+func logBiometricAccess(c echo.Context, resourceType, action string, accesses []biometricAccess) {
+	if len(accesses) == 0 {
+		return
+	}
+
+	var actorID *uuid.UUID
+	var actorRole string
+	if claims, ok := c.Get("user").(jwt.MapClaims); ok {
+		if id, err := uuid.Parse(claimString(claims, "user_id")); err == nil {
+			actorID = &id
+		}
+		actorRole = claimString(claims, "role")
+	}
+
+	logs := make([]models.BiometricAccessLog, 0, len(accesses))
+	for _, a := range accesses {
+		subject := a.SubjectUserID
+		logs = append(logs, models.BiometricAccessLog{
+			ActorID:       actorID,
+			ActorRole:     actorRole,
+			SubjectUserID: &subject,
+			ResourceType:  resourceType,
+			ResourceID:    a.ResourceID,
+			Action:        action,
+			IPAddress:     c.RealIP(),
+		})
+	}
+	if err := config.DB.Create(&logs).Error; err != nil {
+		log.Printf("Error recording biometric access log: %v", err)
+	}
+}
+
+func claimString(claims jwt.MapClaims, key string) string {
+	value, _ := claims[key].(string)
+	return value
+}
+
+// hasBiometricConsent cho biết user có đang đồng ý xử lý dữ liệu sinh trắc học hay không.
+func hasBiometricConsent(tx *gorm.DB, userID uuid.UUID) (bool, error) {
+	var consent models.BiometricConsent
+	err := tx.Where("user_id = ?", userID).Order("created_at DESC").First(&consent).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil && consent.Granted, err
+}
+
+// eraseBiometricData xoá toàn bộ embedding và ảnh minh chứng (điểm danh và sự kiện nhận dạng) của user.
+// Các bản ghi điểm danh (trạng thái) được giữ lại để không làm sai lệch số liệu thống kê.
+func eraseBiometricData(tx *gorm.DB, user models.User, actor attendanceActor) (int64, int64, error) {
+	result := tx.Where("user_id = ?", user.UserID).Delete(&models.FaceEmbedding{})
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+	embeddingsDeleted := result.RowsAffected
+
+	if err := syncPrimaryFaceEmbedding(tx, user); err != nil {
+		return 0, 0, err
+	}
+
+	var evidenceURLs []string
+	if err := tx.Table("attendance").
+		Where("student_id = ? AND evidence_image_url IS NOT NULL AND evidence_image_url <> ''", user.UserID).
+		Pluck("evidence_image_url", &evidenceURLs).Error; err != nil {
+		return 0, 0, err
+	}
+	var eventURLs []string
+	if err := tx.Model(&models.RecognitionEvent{}).
+		Where("student_id = ? AND evidence_image_url IS NOT NULL AND evidence_image_url <> ''", user.UserID).
+		Pluck("evidence_image_url", &eventURLs).Error; err != nil {
+		return 0, 0, err
+	}
+	evidenceURLs = append(evidenceURLs, eventURLs...)
+
+	var scheduleIDs []uuid.UUID
+	if err := tx.Table("attendance").
+		Where("student_id = ? AND evidence_image_url IS NOT NULL", user.UserID).
+		Distinct().Pluck("schedule_id", &scheduleIDs).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := lockAttendance(tx, scheduleIDs...); err != nil {
+		return 0, 0, err
+	}
+
+	// Gỡ đường dẫn ảnh khỏi lịch sử điểm danh, sau đó ghi nhận lần xoá thành một phiên bản mới
+	if err := tx.Exec(`
+		UPDATE attendance_versions
+		SET previous = previous - 'evidence_image_url', current = current - 'evidence_image_url'
+		WHERE student_id = ? OR attendance_id IN (SELECT attendance_id FROM attendance WHERE student_id = ?)`,
+		user.UserID, user.UserID).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Exec(`
+		INSERT INTO attendance_versions
+			(version_id, attendance_id, version, schedule_id, student_id, source, actor_id, actor_role, previous, current, created_at)
+		SELECT gen_random_uuid(), a.attendance_id,
+			COALESCE((SELECT MAX(v.version) FROM attendance_versions v WHERE v.attendance_id = a.attendance_id), 0) + 1,
+			a.schedule_id, a.student_id, ?, ?, ?,
+			to_jsonb(a) - 'evidence_image_url', jsonb_set(to_jsonb(a), '{evidence_image_url}', 'null'), now()
+		FROM (
+			SELECT attendance_id, schedule_id, student_id, attendance_time, status, evidence_image_url, note, channel
+			FROM attendance
+			WHERE student_id = ? AND evidence_image_url IS NOT NULL
+		) a`,
+		models.AttendanceChannelErasure, actor.UserID, actor.Role, user.UserID).Error; err != nil {
+		return 0, 0, err
+	}
+
+	result = tx.Table("attendance").
+		Where("student_id = ? AND evidence_image_url IS NOT NULL", user.UserID).
+		Update("evidence_image_url", nil)
+	if result.Error != nil {
+		return 0, 0, result.Error
+	}
+
+	// Sự kiện nhận dạng của camera cũng lưu ảnh minh chứng
+	if err := tx.Model(&models.RecognitionEvent{}).
+		Where("student_id = ? AND evidence_image_url IS NOT NULL", user.UserID).
+		Update("evidence_image_url", nil).Error; err != nil {
+		return 0, 0, err
+	}
+
+	for _, evidenceURL := range evidenceURLs {
+		removeEvidenceFile(evidenceURL)
+	}
+	return embeddingsDeleted, result.RowsAffected, nil
+}
+
+// removeEvidenceFile xoá file ảnh minh chứng nếu nó nằm trong EVIDENCE_STORAGE_DIR.
+// Ảnh lưu ở nơi khác (dịch vụ camera) chỉ bị gỡ tham chiếu.
+func removeEvidenceFile(evidenceURL string) {
+	dir := os.Getenv("EVIDENCE_STORAGE_DIR")
+	if dir == "" {
+		return
+	}
+
+	p := evidenceURL
+	if u, err := url.Parse(evidenceURL); err == nil {
+		p = u.Path
+	}
+	// Chỉ lấy đường dẫn tương đối bên trong thư mục lưu trữ, không cho phép thoát ra ngoài
+	file := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+p)))
+	if !strings.HasPrefix(file, filepath.Clean(dir)+string(filepath.Separator)) {
+		return
+	}
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing evidence image %s: %v", file, err)
+	}
+}
+
+// recordBiometricConsent lưu trạng thái đồng ý mới; rút lại đồng ý sẽ xoá dữ liệu sinh trắc học.
+func recordBiometricConsent(c echo.Context, user models.User, granted bool, policyVersion string, recordedBy *uuid.UUID) error {
+	consent := models.BiometricConsent{
+		UserID:        user.UserID,
+		Granted:       granted,
+		PolicyVersion: policyVersion,
+		RecordedBy:    recordedBy,
+		IPAddress:     c.RealIP(),
+	}
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&consent).Error; err != nil {
+			return err
+		}
+		if granted {
+			return nil
+		}
+		_, _, err := eraseBiometricData(tx, user, attendanceActorFrom(c))
+		return err
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record consent"})
+	}
+	return c.JSON(http.StatusOK, consent)
+}
+
+// GetMyBiometricConsent trả về trạng thái đồng ý hiện tại của người dùng đang đăng nhập.
+func GetMyBiometricConsent(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "User not found"})
+	}
+
+	var consents []models.BiometricConsent
+	if err := config.DB.Where("user_id = ?", user.UserID).Order("created_at DESC").Find(&consents).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve consent"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"granted": len(consents) > 0 && consents[0].Granted,
+		"history": consents,
+	})
+}
+
+// UpdateMyBiometricConsent cho phép người dùng đồng ý hoặc rút lại đồng ý.
+func UpdateMyBiometricConsent(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "User not found"})
+	}
+
+	var input struct {
+		Granted       bool   `json:"granted"`
+		PolicyVersion string `json:"policy_version"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	if input.Granted && input.PolicyVersion == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "policy_version is required when granting consent"})
+	}
+
+	return recordBiometricConsent(c, user, input.Granted, input.PolicyVersion, &user.UserID)
+}
+
+// GetUserBiometricConsent trả về lịch sử đồng ý của một user (admin).
+func GetUserBiometricConsent(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var consents []models.BiometricConsent
+	if err := config.DB.Where("user_id = ?", user.UserID).Order("created_at DESC").Find(&consents).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve consent"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"granted": len(consents) > 0 && consents[0].Granted,
+		"history": consents,
+	})
+}
+
+// EraseBiometricData thực hiện quyền được xoá: xoá embedding và ảnh minh chứng của user,
+// giữ lại các bản ghi điểm danh để số liệu tổng hợp không thay đổi.
+func EraseBiometricData(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var input struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+
+	admin, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "User not found"})
+	}
+
+	erasure := models.BiometricErasure{
+		UserID:      user.UserID,
+		RequestedBy: &admin.UserID,
+		Reason:      input.Reason,
+	}
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		erasure.EmbeddingsDeleted, erasure.EvidenceCleared, err = eraseBiometricData(tx, user, attendanceActorFrom(c))
+		if err != nil {
+			return err
+		}
+		// Sau khi xoá, dữ liệu sinh trắc học không được thu thập lại nếu chưa có đồng ý mới
+		if err := tx.Create(&models.BiometricConsent{
+			UserID:     user.UserID,
+			Granted:    false,
+			RecordedBy: &admin.UserID,
+			IPAddress:  c.RealIP(),
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&erasure).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to erase biometric data"})
+	}
+
+	logBiometricAccess(c, "face_embedding", "erase", []biometricAccess{{SubjectUserID: user.UserID}})
+	return c.JSON(http.StatusOK, erasure)
+}
+
+// GetBiometricAccessLogs trả về log truy cập dữ liệu sinh trắc học, lọc theo subject_user_id/actor_id.
+func GetBiometricAccessLogs(c echo.Context) error {
+	query := config.DB.Model(&models.BiometricAccessLog{})
+
+	if subjectID := c.QueryParam("subject_user_id"); subjectID != "" {
+		query = query.Where("subject_user_id = ?", subjectID)
+	}
+	if actorID := c.QueryParam("actor_id"); actorID != "" {
+		query = query.Where("actor_id = ?", actorID)
+	}
+
+	var logs []models.BiometricAccessLog
+	if err := query.Order("created_at DESC").Limit(1000).Find(&logs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve access logs"})
+	}
+	return c.JSON(http.StatusOK, logs)
+}
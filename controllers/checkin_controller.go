@@ -0,0 +1,257 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQRRotationSeconds = 30
+	// Số bước trước đó vẫn được chấp nhận để bù thời gian quét và độ trễ mạng
+	qrTokenSkewSteps = 1
+)
+
+// currentCheckInSession trả về phiên QR đang mở của buổi học tại thời điểm now.
+func currentCheckInSession(scheduleID uuid.UUID, now time.Time) (models.CheckInSession, error) {
+	var session models.CheckInSession
+	err := config.DB.Where("schedule_id = ? AND closed_at IS NULL AND opens_at <= ? AND closes_at > ?", scheduleID, now, now).
+		Order("created_at DESC").
+		First(&session).Error
+	return session, err
+}
+
+// qrToken tạo token hiển thị trong mã QR: <session_id>.<step>.<chữ ký HMAC gắn với schedule_id>.
+func qrToken(session models.CheckInSession, step int64) string {
+	signature := utils.SignRotatingToken(session.Secret, session.ScheduleID.String(), step)
+	return session.SessionID.String() + "." + strconv.FormatInt(step, 10) + "." + signature
+}
+
+// loadManagedSchedule tải buổi học theo tham số schedule_id và kiểm tra quyền của giảng viên.
+func loadManagedSchedule(c echo.Context) (models.Schedule, *echo.HTTPError) {
+	var schedule models.Schedule
+	err := config.DB.First(&schedule, "schedule_id = ?", c.Param("schedule_id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return schedule, echo.NewHTTPError(http.StatusNotFound, "Schedule not found")
+	}
+	if err != nil {
+		return schedule, echo.NewHTTPError(http.StatusInternalServerError, "Failed to retrieve schedule")
+	}
+	return schedule, requireClassReviewer(c, schedule.ClassID)
+}
+
+// OpenCheckInSession mở phiên điểm danh QR cho một buổi học. Phiên kéo dài đến hết buổi học
+// (hoặc duration_minutes nếu có); mã QR đổi sau mỗi rotation_seconds giây (10-300, mặc định 30).
+func OpenCheckInSession(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var input struct {
+		RotationSeconds int `json:"rotation_seconds"`
+		DurationMinutes int `json:"duration_minutes"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	if input.RotationSeconds == 0 {
+		input.RotationSeconds = defaultQRRotationSeconds
+	}
+	if input.RotationSeconds < 10 || input.RotationSeconds > 300 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "rotation_seconds must be between 10 and 300"})
+	}
+	if input.DurationMinutes < 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "duration_minutes must not be negative"})
+	}
+
+	now := time.Now()
+	earlyWindow := utils.GetEnvDuration("ATTENDANCE_EARLY_WINDOW", 15*time.Minute)
+	if now.Before(schedule.StartTime.Add(-earlyWindow)) || !now.Before(schedule.EndTime) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Check-in can only be opened during the session"})
+	}
+	closesAt := schedule.EndTime
+	if input.DurationMinutes > 0 {
+		if end := now.Add(time.Duration(input.DurationMinutes) * time.Minute); end.Before(closesAt) {
+			closesAt = end
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to generate session secret"})
+	}
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	createdBy, _ := uuid.Parse(claimString(claims, "user_id"))
+
+	session := models.CheckInSession{
+		SessionID:       uuid.New(),
+		ScheduleID:      schedule.ScheduleID,
+		Secret:          secret,
+		RotationSeconds: input.RotationSeconds,
+		OpensAt:         now,
+		ClosesAt:        closesAt,
+		CreatedBy:       createdBy,
+	}
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Chỉ một phiên mở cho mỗi buổi học: phiên mới thay thế phiên cũ
+		if err := tx.Model(&models.CheckInSession{}).
+			Where("schedule_id = ? AND closed_at IS NULL AND closes_at > ?", schedule.ScheduleID, now).
+			Update("closed_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Create(&session).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to open check-in session"})
+	}
+
+	return c.JSON(http.StatusCreated, session)
+}
+
+// GetCheckInQR trả về token hiện tại để màn hình của giảng viên hiển thị thành mã QR.
+// Client gọi lại trước expires_at để lấy mã mới.
+func GetCheckInQR(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	now := time.Now()
+	session, err := currentCheckInSession(schedule.ScheduleID, now)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "No open check-in session"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve check-in session"})
+	}
+
+	period := time.Duration(session.RotationSeconds) * time.Second
+	step := utils.RotatingTokenStep(now, period)
+	expiresAt := time.Unix((step+1)*int64(session.RotationSeconds), 0)
+	if expiresAt.After(session.ClosesAt) {
+		expiresAt = session.ClosesAt
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"session_id": session.SessionID,
+		"token":      qrToken(session, step),
+		"expires_at": expiresAt,
+		"closes_at":  session.ClosesAt,
+	})
+}
+
+// CloseCheckInSession đóng sớm phiên điểm danh QR đang mở của buổi học.
+func CloseCheckInSession(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	now := time.Now()
+	result := config.DB.Model(&models.CheckInSession{}).
+		Where("schedule_id = ? AND closed_at IS NULL AND closes_at > ?", schedule.ScheduleID, now).
+		Update("closed_at", now)
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to close check-in session"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "No open check-in session"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"message": "Check-in session closed"})
+}
+
+// CheckInWithQR cho phép sinh viên tự điểm danh bằng token quét từ mã QR.
+// Token phải còn hiệu lực (bước hiện tại hoặc liền trước) và phiên phải đang mở.
+func CheckInWithQR(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	studentID, err := uuid.Parse(claimString(claims, "user_id"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "Invalid token claims"})
+	}
+
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	parts := strings.Split(input.Token, ".")
+	if len(parts) != 3 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid check-in token"})
+	}
+	sessionID, err := uuid.Parse(parts[0])
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid check-in token"})
+	}
+	step, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid check-in token"})
+	}
+
+	now := time.Now()
+	var session models.CheckInSession
+	if err := config.DB.First(&session, "session_id = ?", sessionID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid check-in token"})
+	}
+	if session.ClosedAt != nil || now.Before(session.OpensAt) || !now.Before(session.ClosesAt) {
+		return c.JSON(http.StatusGone, echo.Map{"error": "Check-in session is closed"})
+	}
+	current := utils.RotatingTokenStep(now, time.Duration(session.RotationSeconds)*time.Second)
+	if !utils.VerifyRotatingToken(session.Secret, session.ScheduleID.String(), step, parts[2], current, qrTokenSkewSteps) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Check-in token is invalid or expired"})
+	}
+
+	var schedule models.Schedule
+	if err := config.DB.First(&schedule, "schedule_id = ?", session.ScheduleID).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve schedule"})
+	}
+	enrolled, err := isEnrolled(schedule.ClassID, studentID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check enrollment"})
+	}
+	if !enrolled {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "You are not enrolled in this class"})
+	}
+
+	var attendance models.Attendance
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockAttendance(tx, schedule.ScheduleID); err != nil {
+			return err
+		}
+		// Mỗi sinh viên chỉ điểm danh một lần cho mỗi buổi
+		var existing int64
+		if err := tx.Model(&models.Attendance{}).
+			Where("schedule_id = ? AND student_id = ? AND status IN ?", schedule.ScheduleID, studentID,
+				[]string{models.AttendanceStatusPresent, models.AttendanceStatusLate}).
+			Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return echo.NewHTTPError(http.StatusConflict, "You have already checked in for this session")
+		}
+		var err error
+		attendance, _, err = recordArrival(tx, schedule, studentID, now, nil, models.AttendanceChannelQR, attendanceActorFrom(c))
+		return err
+	})
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record attendance"})
+	}
+
+	return c.JSON(http.StatusOK, attendance)
+}
@@ -3,14 +3,18 @@ package controllers
 import (
 	"cms-backend/config"
 	"cms-backend/models"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func GetClassesByLecturer(c echo.Context) error {
@@ -79,10 +83,12 @@ func AttendanceSummaryHandler(c echo.Context) error {
 
 	// Định nghĩa struct cho kết quả trả về
 	type AttendanceSummary struct {
-		TotalStudents int `json:"total_students"`
-		CountAbsent   int `json:"count_absent"`
-		CountPresent  int `json:"count_present"`
-		CountLate     int `json:"count_late"`
+		TotalStudents int     `json:"total_students"`
+		CountAbsent   int     `json:"count_absent"`
+		CountPresent  int     `json:"count_present"`
+		CountLate     int     `json:"count_late"`
+		CountExcused  int     `json:"count_excused"`
+		AbsenceUnits  float64 `json:"absence_units"` // Số buổi vắng quy đổi theo chính sách (tính cả đi muộn)
 	}
 
 	var summary AttendanceSummary
@@ -91,12 +97,15 @@ func AttendanceSummaryHandler(c echo.Context) error {
 	query := config.DB.Table("attendance a").
 		Select(`
 			COUNT(DISTINCT a.student_id) AS total_students,
-			SUM(CASE WHEN a.status = 'absent' THEN 1 ELSE 0 END) AS count_absent,
-			SUM(CASE WHEN a.status = 'present' THEN 1 ELSE 0 END) AS count_present,
-			SUM(CASE WHEN a.status = 'late' THEN 1 ELSE 0 END) AS count_late
+			`+countStatusSQL("a", models.AttendanceStatusAbsent)+` AS count_absent,
+			`+countStatusSQL("a", models.AttendanceStatusPresent)+` AS count_present,
+			`+countStatusSQL("a", models.AttendanceStatusLate)+` AS count_late,
+			`+countStatusSQL("a", models.AttendanceStatusExcused)+` AS count_excused,
+			`+absenceUnitsSQL("a")+` AS absence_units
 		`).
 		Joins("JOIN schedules cs ON a.schedule_id = cs.schedule_id").
 		Joins("JOIN classes c ON cs.class_id = c.class_id").
+		Joins(policyJoinSQL("c")).
 		Where("c.lecturer_id = ?", lecturerID)
 
 	// Nếu có class_id, thêm điều kiện lọc
@@ -140,6 +149,28 @@ func GetAttendanceDetails(c echo.Context) error {
 		EvidenceImageUrl string `json:"evidence_image_url"`
 	}
 
+	// Thực hiện query và sắp xếp theo s.start_time giảm dần
+	dbQuery := attendanceDetailQuery(lecturerID, classID, scheduleID)
+	if err := dbQuery.Order("s.start_time DESC").Scan(&records).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve data"})
+	}
+
+	// Ghi log truy cập ảnh minh chứng
+	var accesses []biometricAccess
+	for _, r := range records {
+		if r.EvidenceImageUrl != "" {
+			accesses = append(accesses, biometricAccess{SubjectUserID: r.StudentID, ResourceID: r.AttendanceId.String()})
+		}
+	}
+	logBiometricAccess(c, "evidence_image", "read", accesses)
+
+	// Trả về kết quả dạng JSON
+	return c.JSON(http.StatusOK, records)
+}
+
+// attendanceDetailQuery xây dựng truy vấn chi tiết điểm danh theo giảng viên, lọc thêm theo lớp
+// và buổi học nếu có. Dùng chung cho API /attendance-detail và khi xuất file.
+func attendanceDetailQuery(lecturerID, classID, scheduleID string) *gorm.DB {
 	// Xây dựng query cơ bản với điều kiện lecturer_id
 	dbQuery := config.DB.Table("attendance a").
 		Select(`a.attendance_id,a.student_id, st.student_code, u.first_name, u.last_name,a.schedule_id,
@@ -157,25 +188,17 @@ func GetAttendanceDetails(c echo.Context) error {
 		dbQuery = dbQuery.Where("c.class_id = ?", classID)
 	}
 
-	// Thực hiện query và sắp xếp theo s.start_time giảm dần
 	if scheduleID != "" {
 		dbQuery = dbQuery.Where("a.schedule_id = ?", scheduleID)
 	}
-
-	// Thực hiện query và sắp xếp theo s.start_time giảm dần
-	if err := dbQuery.Order("s.start_time DESC").Scan(&records).Error; err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve data"})
-	}
-
-	// Trả về kết quả dạng JSON
-	return c.JSON(http.StatusOK, records)
+	return dbQuery
 }
 
 func UpdateAttendance(c echo.Context) error {
 	type AttendanceUpdate struct {
 		AttendanceID     uuid.UUID `json:"attendance_id"`      // Khóa chính để cập nhật
-		StudentID        uuid.UUID `json:"student_id"`         // UUID của học sinh
-		ScheduleID       uuid.UUID `json:"schedule_id"`        // UUID của lịch học
+		StudentID        uuid.UUID `json:"student_id"`         // Không được đổi, nếu truyền phải trùng với bản ghi
+		ScheduleID       uuid.UUID `json:"schedule_id"`        // Không được đổi, nếu truyền phải trùng với bản ghi
 		AttendanceTime   string    `json:"attendance_time"`    // Dạng string theo RFC3339, ví dụ "2025-03-29T14:50:43.590Z"
 		Status           string    `json:"status"`             // "present", "absent", "late", ...
 		EvidenceImageURL *string   `json:"evidence_image_url"` // Có thể là null
@@ -186,41 +209,113 @@ func UpdateAttendance(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
 	}
 
-	// Parse trường attendance_time sang kiểu time.Time, nếu không có hoặc lỗi thì lấy thời gian hiện tại
-	var attTime time.Time
+	// Parse trường attendance_time (RFC3339); nếu không truyền thì giữ thời điểm đã lưu
+	var attTime *time.Time
 	if att.AttendanceTime != "" {
 		t, err := time.Parse(time.RFC3339, att.AttendanceTime)
 		if err != nil {
-			log.Printf("Error parsing attendance_time: %v", err)
-			attTime = time.Now().UTC()
-		} else {
-			attTime = t
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "attendance_time must be RFC3339"})
 		}
-	} else {
-		attTime = time.Now().UTC()
+		attTime = &t
+	}
+	switch att.Status {
+	case "", models.AttendanceStatusPresent, models.AttendanceStatusLate,
+		models.AttendanceStatusAbsent, models.AttendanceStatusExcused:
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid status"})
 	}
 
-	// Câu lệnh SQL cập nhật bản ghi dựa trên attendance_id
+	// Câu lệnh SQL cập nhật bản ghi dựa trên attendance_id. Sửa tay không được chuyển bản ghi
+	// sang sinh viên hoặc buổi học khác nên schedule_id và student_id không được cập nhật.
 	query := `
 		UPDATE attendance
-		SET schedule_id = $1,
-			attendance_time = $2,
-			student_id = $3,
-			status = $4,
-			evidence_image_url = $5,
-			note = $6
-		WHERE attendance_id = $7
+		SET attendance_time = $1,
+			status = $2,
+			evidence_image_url = $3,
+			note = $4,
+			channel = $5
+		WHERE attendance_id = $6
 	`
-	err := config.DB.Exec(query,
-		att.ScheduleID,
-		attTime,
-		att.StudentID,
-		att.Status,
-		att.EvidenceImageURL,
-		att.Note,
-		att.AttendanceID,
-	).Error
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		// Khoá buổi học trước rồi mới đọc bản ghi, để phiên bản "trước" lưu vào lịch sử không bị cũ
+		var ref struct{ ScheduleID uuid.UUID }
+		if err := tx.Model(&models.Attendance{}).Select("schedule_id").
+			Where("attendance_id = ?", att.AttendanceID).Take(&ref).Error; err != nil {
+			return err
+		}
+		if err := lockAttendance(tx, ref.ScheduleID); err != nil {
+			return err
+		}
+		var current models.Attendance
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&current, "attendance_id = ?", att.AttendanceID).Error; err != nil {
+			return err
+		}
+		if (att.ScheduleID != uuid.Nil && att.ScheduleID != current.ScheduleID) ||
+			(att.StudentID != uuid.Nil && att.StudentID != current.StudentID) {
+			return echo.NewHTTPError(http.StatusBadRequest, "schedule_id and student_id of an attendance record cannot be changed")
+		}
+
+		// Giảng viên chỉ được sửa điểm danh của lớp mình phụ trách
+		var schedule models.Schedule
+		if err := tx.First(&schedule, "schedule_id = ?", current.ScheduleID).Error; err != nil {
+			return err
+		}
+		if httpErr := requireClassReviewer(c, schedule.ClassID); httpErr != nil {
+			return httpErr
+		}
+		// Không cho sửa điểm danh của buổi học đã chốt
+		if err := ensureAttendanceEditable(tx, current.ScheduleID); err != nil {
+			return err
+		}
+
+		// Trường không gửi lên giữ nguyên giá trị đang lưu
+		attendedAt := current.AttendanceTime
+		if attTime != nil {
+			attendedAt = *attTime
+		}
+		evidenceImageURL, note := current.EvidenceImageURL, current.Note
+		if att.EvidenceImageURL != nil {
+			evidenceImageURL = att.EvidenceImageURL
+		}
+		if att.Note != nil {
+			note = att.Note
+		}
+		// Giảng viên chọn trạng thái thì giữ nguyên; không chọn thì tính theo chính sách
+		// điểm danh của lớp, giống với luồng điểm danh tự động
+		if att.Status == "" {
+			policy, _, err := effectiveAttendancePolicy(tx, schedule.ClassID)
+			if err != nil {
+				return err
+			}
+			att.Status = attendanceStatusFor(policy, schedule, attendedAt)
+		}
+		if err := tx.Exec(query,
+			attendedAt,
+			att.Status,
+			evidenceImageURL,
+			note,
+			models.AttendanceChannelManual,
+			att.AttendanceID,
+		).Error; err != nil {
+			return err
+		}
 
+		// Lưu phiên bản mới vào lịch sử thay đổi
+		var updated models.Attendance
+		if err := tx.First(&updated, "attendance_id = ?", att.AttendanceID).Error; err != nil {
+			return err
+		}
+		return recordAttendanceVersion(tx, &current, updated, models.AttendanceChannelManual, attendanceActorFrom(c))
+	})
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return c.JSON(httpErr.Code, map[string]interface{}{"error": httpErr.Message})
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Attendance not found"})
+	}
 	if err != nil {
 		log.Printf("Error updating attendance: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update attendance"})
@@ -232,287 +327,95 @@ func UpdateAttendance(c echo.Context) error {
 		"message": "Attendance updated successfully",
 	})
 }
+
+// GetAttendanceReport trả về số liệu điểm danh theo từng khoảng thời gian liên tiếp.
+// Tham số: from, to (YYYY-MM-DD, tính cả ngày to), granularity (day, week, month, term)
+// và tz (múi giờ IANA, ví dụ Asia/Ho_Chi_Minh). Các tham số cũ filter/year/month/week vẫn được hỗ trợ.
 func GetAttendanceReport(c echo.Context) error {
 	lecturerID := c.Param("lecturer_id")
-	filter := c.QueryParam("filter") // "week", "month", or "year"
-	year := c.QueryParam("year")
-	month := c.QueryParam("month")
-	week := c.QueryParam("week") // dùng khi filter là "week"
 	classID := c.QueryParam("class_id")
 
-	if filter == "" {
-		filter = "month" // mặc định
+	loc, err := reportLocation(c.QueryParam("tz"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid tz"})
 	}
 
-	type AttendanceReport struct {
-		Period  string `json:"period"` // week: ngày, month: tuần, year: tháng
-		Present int    `json:"present"`
-		Late    int    `json:"late"`
-		Absent  int    `json:"absent"`
+	from, to, granularity, err := attendanceReportRange(c, loc)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	buckets, err := buildReportBuckets(from, to, granularity)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
 	}
 
-	var reports []AttendanceReport
-	var query string
-	var params []interface{}
+	where := "c.lecturer_id = ?"
+	args := []interface{}{lecturerID}
+	if classID != "" {
+		where += " AND c.class_id = ?"
+		args = append(args, classID)
+	}
 
-	switch filter {
-	case "year":
-		// Báo cáo theo năm: trả về đầy đủ 12 tháng
-		yearInt, err := strconv.Atoi(year)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid year"})
-		}
-		// Tính ngày bắt đầu của năm (đầu năm) theo múi giờ địa phương
-		startOfYear := time.Date(yearInt, time.January, 1, 0, 0, 0, 0, time.Local)
-		// Cách tính endOfYear: thêm 1 năm vào startOfYear
-		// (generate_series sẽ tạo ra các mốc tháng từ startOfYear đến endOfYear - 1 day)
-		endOfYear := startOfYear.AddDate(1, 0, 0)
-
-		// Truy vấn: Sử dụng CTE để tạo ra dãy 12 tháng, sau đó LEFT JOIN với dữ liệu điểm danh đã được tổng hợp
-		if classID == "" {
-			query = `
-			WITH months AS (
-				SELECT generate_series(?::timestamp, ?::timestamp - interval '1 day', interval '1 month') AS month_start
-			),
-			data AS (
-				SELECT s.start_time, a.status
-				FROM schedules s
-				JOIN attendance a ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE c.lecturer_id = ?
-			)
-			SELECT 
-				TO_CHAR(m.month_start, 'MM') AS period,
-				COALESCE(SUM(CASE WHEN d.status = 'present' THEN 1 ELSE 0 END), 0) AS present,
-				COALESCE(SUM(CASE WHEN d.status = 'late' THEN 1 ELSE 0 END), 0) AS late,
-				COALESCE(SUM(CASE WHEN d.status = 'absent' THEN 1 ELSE 0 END), 0) AS absent
-			FROM months m
-			LEFT JOIN data d ON d.start_time >= m.month_start
-				AND d.start_time < m.month_start + interval '1 month'
-			GROUP BY m.month_start
-			ORDER BY m.month_start;
-		`
-			// Tham số: startOfYear, endOfYear, lecturerID
-			params = []interface{}{startOfYear, endOfYear, lecturerID}
-		} else {
-			query = `
-			WITH months AS (
-				SELECT generate_series(?::timestamp, ?::timestamp - interval '1 day', interval '1 month') AS month_start
-			),
-			data AS (
-				SELECT s.start_time, a.status
-				FROM schedules s
-				JOIN attendance a ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE c.lecturer_id = ? AND c.class_id = ?
-			)
-			SELECT 
-				TO_CHAR(m.month_start, 'MM') AS period,
-				COALESCE(SUM(CASE WHEN d.status = 'present' THEN 1 ELSE 0 END), 0) AS present,
-				COALESCE(SUM(CASE WHEN d.status = 'late' THEN 1 ELSE 0 END), 0) AS late,
-				COALESCE(SUM(CASE WHEN d.status = 'absent' THEN 1 ELSE 0 END), 0) AS absent
-			FROM months m
-			LEFT JOIN data d ON d.start_time >= m.month_start
-				AND d.start_time < m.month_start + interval '1 month'
-			GROUP BY m.month_start
-			ORDER BY m.month_start;
-		`
-			// Tham số: startOfYear, endOfYear, lecturerID, classID
-			params = []interface{}{startOfYear, endOfYear, lecturerID, classID}
-		}
+	reports, err := runAttendanceReport(buckets, where, args...)
+	if err != nil {
+		c.Logger().Error("Error executing query:", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve data"})
+	}
 
-	case "month":
-		// Báo cáo theo tháng: chia thành 4 khoảng (tuần)
-		yearInt, err := strconv.Atoi(year)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid year"})
-		}
-		monthInt, err := strconv.Atoi(month)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid month"})
-		}
-		baseDate := time.Date(yearInt, time.Month(monthInt), 1, 0, 0, 0, 0, time.Local)
-		week1Start := baseDate
-		week1End := baseDate.AddDate(0, 0, 7)
-		week2Start := week1End
-		week2End := baseDate.AddDate(0, 0, 14)
-		week3Start := week2End
-		week3End := baseDate.AddDate(0, 0, 21)
-		week4Start := week3End
-		week4End := baseDate.AddDate(0, 1, 0) // ngày đầu tháng kế tiếp
-
-		baseCondition := "c.lecturer_id = ?"
-		if classID != "" {
-			baseCondition += " AND c.class_id = ?"
-		}
-		query = `
-			SELECT 'Tuần 1' AS period,
-				COALESCE(present,0) AS present,
-				COALESCE(late,0) AS late,
-				COALESCE(absent,0) AS absent
-			FROM (
-				SELECT COUNT(*) FILTER (WHERE a.status = 'present') AS present,
-					   COUNT(*) FILTER (WHERE a.status = 'late') AS late,
-					   COUNT(*) FILTER (WHERE a.status = 'absent') AS absent
-				FROM attendance a
-				JOIN schedules s ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE ` + baseCondition + ` AND s.start_time >= ? AND s.start_time < ?
-			) sub
-			UNION ALL
-			SELECT 'Tuần 2' AS period,
-				COALESCE(present,0),
-				COALESCE(late,0),
-				COALESCE(absent,0)
-			FROM (
-				SELECT COUNT(*) FILTER (WHERE a.status = 'present') AS present,
-					   COUNT(*) FILTER (WHERE a.status = 'late') AS late,
-					   COUNT(*) FILTER (WHERE a.status = 'absent') AS absent
-				FROM attendance a
-				JOIN schedules s ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE ` + baseCondition + ` AND s.start_time >= ? AND s.start_time < ?
-			) sub
-			UNION ALL
-			SELECT 'Tuần 3' AS period,
-				COALESCE(present,0),
-				COALESCE(late,0),
-				COALESCE(absent,0)
-			FROM (
-				SELECT COUNT(*) FILTER (WHERE a.status = 'present') AS present,
-					   COUNT(*) FILTER (WHERE a.status = 'late') AS late,
-					   COUNT(*) FILTER (WHERE a.status = 'absent') AS absent
-				FROM attendance a
-				JOIN schedules s ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE ` + baseCondition + ` AND s.start_time >= ? AND s.start_time < ?
-			) sub
-			UNION ALL
-			SELECT 'Tuần 4' AS period,
-				COALESCE(present,0),
-				COALESCE(late,0),
-				COALESCE(absent,0)
-			FROM (
-				SELECT COUNT(*) FILTER (WHERE a.status = 'present') AS present,
-					   COUNT(*) FILTER (WHERE a.status = 'late') AS late,
-					   COUNT(*) FILTER (WHERE a.status = 'absent') AS absent
-				FROM attendance a
-				JOIN schedules s ON a.schedule_id = s.schedule_id
-				JOIN classes c ON s.class_id = c.class_id
-				WHERE ` + baseCondition + ` AND s.start_time >= ? AND s.start_time < ?
-			) sub;
-		`
-		params = []interface{}{}
-		// Tuần 1
-		params = append(params, lecturerID)
-		if classID != "" {
-			params = append(params, classID)
-		}
-		params = append(params, week1Start, week1End)
-		// Tuần 2
-		params = append(params, lecturerID)
-		if classID != "" {
-			params = append(params, classID)
-		}
-		params = append(params, week2Start, week2End)
-		// Tuần 3
-		params = append(params, lecturerID)
-		if classID != "" {
-			params = append(params, classID)
-		}
-		params = append(params, week3Start, week3End)
-		// Tuần 4
-		params = append(params, lecturerID)
-		if classID != "" {
-			params = append(params, classID)
-		}
-		params = append(params, week4Start, week4End)
-	case "week":
-		// Báo cáo theo tuần: trả về 7 ngày của tuần được chọn sử dụng generate_series
-		yearInt, err := strconv.Atoi(year)
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid year"})
-		}
-		monthInt, err := strconv.Atoi(month)
+	return c.JSON(http.StatusOK, reports)
+}
+
+// attendanceReportRange xác định phạm vi [from, to) và độ chi tiết của báo cáo.
+// Khi không có from/to, tham số cũ được quy đổi: filter=year chia theo tháng, filter=month
+// chia theo tuần ISO trong tháng, filter=week lấy 7 ngày bắt đầu từ ngày (week-1)*7+1 của tháng.
+func attendanceReportRange(c echo.Context, loc *time.Location) (time.Time, time.Time, string, error) {
+	granularity := c.QueryParam("granularity")
+
+	if fromParam, toParam := c.QueryParam("from"), c.QueryParam("to"); fromParam != "" || toParam != "" {
+		from, err := time.ParseInLocation(reportDateLayout, fromParam, loc)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid month"})
+			return time.Time{}, time.Time{}, "", errors.New("Invalid from, expected YYYY-MM-DD")
 		}
-		weekInt, err := strconv.Atoi(week)
+		to, err := time.ParseInLocation(reportDateLayout, toParam, loc)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid week"})
+			return time.Time{}, time.Time{}, "", errors.New("Invalid to, expected YYYY-MM-DD")
 		}
-		// Tính ngày đầu tiên của tháng theo múi giờ địa phương
-		firstOfMonth := time.Date(yearInt, time.Month(monthInt), 1, 0, 0, 0, 0, time.Local)
-		// Tính ngày bắt đầu của tuần thứ weekInt trong tháng
-		startDate := firstOfMonth.AddDate(0, 0, (weekInt-1)*7)
-		// Tính endDate: cộng thêm 7 ngày, để bao gồm đủ 7 ngày của tuần
-		endDate := startDate.AddDate(0, 0, 7)
-
-		// Sử dụng generate_series để tạo dãy 7 ngày
-		// Đưa các điều kiện của lecturer (và class nếu có) vào trong phần LEFT JOIN của bảng classes,
-		// để không loại bỏ các ngày không có dữ liệu (NULL) từ generate_series.
-		if classID == "" {
-			query = `
-			WITH days AS (
-				SELECT generate_series(?::timestamp, ?::timestamp - interval '1 second', interval '1 day') AS day_date
-			)
-			SELECT 
-				TO_CHAR(d.day_date, 'DD') AS period,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'present'), 0) AS present,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'late'), 0) AS late,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'absent'), 0) AS absent
-			FROM days d
-			LEFT JOIN schedules s 
-				ON s.start_time >= d.day_date 
-			   AND s.start_time < d.day_date + interval '1 day'
-			LEFT JOIN attendance a 
-				ON a.schedule_id = s.schedule_id
-			LEFT JOIN classes c 
-				ON s.class_id = c.class_id AND c.lecturer_id = ?
-			WHERE d.day_date >= ? AND d.day_date < ?
-			GROUP BY d.day_date
-			ORDER BY d.day_date;
-		`
-			// Thứ tự tham số: startDate, endDate, lecturerID, startDate, endDate
-			params = []interface{}{startDate, endDate, lecturerID, startDate, endDate}
-		} else {
-			query = `
-			WITH days AS (
-				SELECT generate_series(?::timestamp, ?::timestamp - interval '1 second', interval '1 day') AS day_date
-			)
-			SELECT 
-				TO_CHAR(d.day_date, 'DD') AS period,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'present'), 0) AS present,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'late'), 0) AS late,
-				COALESCE(COUNT(*) FILTER (WHERE a.status = 'absent'), 0) AS absent
-			FROM days d
-			LEFT JOIN schedules s 
-				ON s.start_time >= d.day_date 
-			   AND s.start_time < d.day_date + interval '1 day'
-			LEFT JOIN attendance a 
-				ON a.schedule_id = s.schedule_id
-			LEFT JOIN classes c 
-				ON s.class_id = c.class_id AND c.lecturer_id = ? AND c.class_id = ?
-			WHERE d.day_date >= ? AND d.day_date < ?
-			GROUP BY d.day_date
-			ORDER BY d.day_date;
-		`
-			// Thứ tự tham số: startDate, endDate, lecturerID, classID, startDate, endDate
-			params = []interface{}{startDate, endDate, lecturerID, classID, startDate, endDate}
+		if granularity == "" {
+			granularity = ReportGranularityDay
 		}
-
-	default:
-		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid filter"})
+		return from, to.AddDate(0, 0, 1), granularity, nil
 	}
 
-	//====================
-	err := config.DB.Raw(query, params...).Scan(&reports).Error
+	filter := c.QueryParam("filter")
+	if filter == "" {
+		filter = "month" // mặc định
+	}
+	year, err := strconv.Atoi(c.QueryParam("year"))
 	if err != nil {
-		c.Logger().Error("Error executing query:", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve data"})
+		return time.Time{}, time.Time{}, "", errors.New("Invalid year")
+	}
+	if filter == "year" {
+		from := time.Date(year, time.January, 1, 0, 0, 0, 0, loc)
+		return from, from.AddDate(1, 0, 0), ReportGranularityMonth, nil
 	}
 
-	return c.JSON(http.StatusOK, reports)
+	month, err := strconv.Atoi(c.QueryParam("month"))
+	if err != nil || month < 1 || month > 12 {
+		return time.Time{}, time.Time{}, "", errors.New("Invalid month")
+	}
+	firstOfMonth := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, loc)
+	switch filter {
+	case "month":
+		return firstOfMonth, firstOfMonth.AddDate(0, 1, 0), ReportGranularityWeek, nil
+	case "week":
+		week, err := strconv.Atoi(c.QueryParam("week"))
+		if err != nil || week < 1 {
+			return time.Time{}, time.Time{}, "", errors.New("Invalid week")
+		}
+		from := firstOfMonth.AddDate(0, 0, (week-1)*7)
+		return from, from.AddDate(0, 0, 7), ReportGranularityDay, nil
+	}
+	return time.Time{}, time.Time{}, "", errors.New("Invalid filter")
 }
 
 func GetStudentsInClass(c echo.Context) error {
@@ -574,6 +477,18 @@ func AddStudentToClass(c echo.Context) error {
 		})
 	}
 
+	// Giảng viên chỉ được thêm sinh viên vào lớp mình phụ trách; kiểm tra hết trước khi ghi
+	checked := map[uuid.UUID]bool{}
+	for _, student := range req {
+		if checked[student.ClassID] {
+			continue
+		}
+		if httpErr := requireClassReviewer(c, student.ClassID); httpErr != nil {
+			return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+		}
+		checked[student.ClassID] = true
+	}
+
 	// Danh sách các sinh viên đã được thêm thành công
 	var successfullyAdded []string
 
@@ -627,28 +542,20 @@ func AddStudentToClass(c echo.Context) error {
 	})
 }
 
-// API endpoint to get attendance summary by lecturer and class_id
-func GetStudentAttendanceSummary(c echo.Context) error {
-	// Lấy lecturer_id từ URL parameter
-	lecturerID := c.Param("lecturer_id")
-	// Lấy class_id và course_id từ query parameters
-	classID := c.QueryParam("class_id")
-	courseId := c.QueryParam("course_id")
-
-	if lecturerID == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Lecturer ID is required"})
-	}
-
-	// Truy vấn chính: lấy dữ liệu điểm danh nếu có
+// studentAttendanceSummaryQuery xây dựng truy vấn tổng hợp điểm danh theo từng sinh viên của giảng viên,
+// lọc thêm theo lớp và khoá học nếu có. Dùng chung cho API và khi xuất file.
+func studentAttendanceSummaryQuery(lecturerID, classID, courseID string) (string, []interface{}) {
 	query := `
 		SELECT 
 			s.student_id AS "studentId",
 			s.student_code AS "studentCode",
 			u.first_name || ' ' || u.last_name AS "fullName",
 			COUNT(a.attendance_id) AS "attendanceDays",
-			COALESCE(SUM(CASE WHEN a.status = 'present' THEN 1 ELSE 0 END), 0) AS "presentDays",  
-			COALESCE(SUM(CASE WHEN a.status = 'late' THEN 1 ELSE 0 END), 0) AS "lateDays",  
-			COALESCE(SUM(CASE WHEN a.status = 'absent' THEN 1 ELSE 0 END), 0) AS "absentDays"  
+			` + countStatusSQL("a", models.AttendanceStatusPresent) + ` AS "presentDays",
+			` + countStatusSQL("a", models.AttendanceStatusLate) + ` AS "lateDays",
+			` + countStatusSQL("a", models.AttendanceStatusAbsent) + ` AS "absentDays",
+			` + countStatusSQL("a", models.AttendanceStatusExcused) + ` AS "excusedDays",
+			` + absenceUnitsSQL("a") + ` AS "absenceUnits"
 		FROM 
 			students s
 		JOIN 
@@ -659,39 +566,26 @@ func GetStudentAttendanceSummary(c echo.Context) error {
 			schedules sc ON sc.schedule_id = a.schedule_id
 		JOIN 
 			classes c ON c.class_id = sc.class_id
+		` + policyJoinSQL("c") + `
 		WHERE 
 			c.lecturer_id = ?`
+	params := []interface{}{lecturerID}
 	// Nếu có điều kiện bổ sung về class_id và course_id thì thêm
-	if classID != "" && courseId != "" {
-		query += ` AND c.class_id = ? AND c.course_id = ?`
-	} else if classID != "" {
+	if classID != "" {
 		query += ` AND c.class_id = ?`
-	} else if courseId != "" {
+		params = append(params, classID)
+	}
+	if courseID != "" {
 		query += ` AND c.course_id = ?`
+		params = append(params, courseID)
 	}
 	query += ` GROUP BY s.student_id, s.student_code, u.first_name, u.last_name ORDER BY s.student_id`
+	return query, params
+}
 
-	var results []map[string]interface{}
-	var err error
-
-	if classID != "" && courseId != "" {
-		err = config.DB.Raw(query, lecturerID, classID, courseId).Scan(&results).Error
-	} else if classID != "" {
-		err = config.DB.Raw(query, lecturerID, classID).Scan(&results).Error
-	} else if courseId != "" {
-		err = config.DB.Raw(query, lecturerID, courseId).Scan(&results).Error
-	} else {
-		err = config.DB.Raw(query, lecturerID).Scan(&results).Error
-	}
-
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch data"})
-	}
-
-	// Nếu truy vấn chính không trả về dữ liệu (tức là không có thông tin attendance/schedule),
-	// thực hiện fallback query để lấy danh sách học sinh từ bảng ánh xạ (class_students)
-	if len(results) == 0 {
-		fallbackQuery := `
+// enrolledStudentsSummaryQuery liệt kê sinh viên của lớp với số liệu 0, dùng khi lớp chưa có dữ liệu điểm danh.
+func enrolledStudentsSummaryQuery(lecturerID, classID, courseID string) (string, []interface{}) {
+	query := `
 		SELECT 
 			s.student_id AS "studentId",
 			s.student_code AS "studentCode",
@@ -699,7 +593,9 @@ func GetStudentAttendanceSummary(c echo.Context) error {
 			0 AS "attendanceDays",
 			0 AS "presentDays",
 			0 AS "lateDays",
-			0 AS "absentDays"
+			0 AS "absentDays",
+			0 AS "excusedDays",
+			0 AS "absenceUnits"
 		FROM 
 			class_students cs
 		JOIN 
@@ -711,13 +607,39 @@ func GetStudentAttendanceSummary(c echo.Context) error {
 		WHERE 
 			cs.class_id = ? 
 			AND c.lecturer_id = ?`
-		// Nếu có courseId, thêm điều kiện cho course
-		if courseId != "" {
-			fallbackQuery += ` AND c.course_id = ?`
-			err = config.DB.Raw(fallbackQuery, classID, lecturerID, courseId).Scan(&results).Error
-		} else {
-			err = config.DB.Raw(fallbackQuery, classID, lecturerID).Scan(&results).Error
-		}
+	params := []interface{}{classID, lecturerID}
+	// Nếu có courseID, thêm điều kiện cho course
+	if courseID != "" {
+		query += ` AND c.course_id = ?`
+		params = append(params, courseID)
+	}
+	return query, params
+}
+
+// API endpoint to get attendance summary by lecturer and class_id
+func GetStudentAttendanceSummary(c echo.Context) error {
+	// Lấy lecturer_id từ URL parameter
+	lecturerID := c.Param("lecturer_id")
+	// Lấy class_id và course_id từ query parameters
+	classID := c.QueryParam("class_id")
+	courseId := c.QueryParam("course_id")
+
+	if lecturerID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Lecturer ID is required"})
+	}
+
+	// Truy vấn chính: lấy dữ liệu điểm danh nếu có
+	query, params := studentAttendanceSummaryQuery(lecturerID, classID, courseId)
+	var results []map[string]interface{}
+	if err := config.DB.Raw(query, params...).Scan(&results).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch data"})
+	}
+
+	// Nếu truy vấn chính không trả về dữ liệu (tức là không có thông tin attendance/schedule),
+	// thực hiện fallback query để lấy danh sách học sinh từ bảng ánh xạ (class_students)
+	if len(results) == 0 {
+		fallbackQuery, params := enrolledStudentsSummaryQuery(lecturerID, classID, courseId)
+		err := config.DB.Raw(fallbackQuery, params...).Scan(&results).Error
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch fallback data"})
 		}
@@ -749,8 +671,13 @@ func GetClassesByCourses(c echo.Context) error {
 	}
 
 	// Truy vấn cơ sở dữ liệu để lấy danh sách các lớp theo course_id
+	// (giảng viên chỉ thấy các lớp mình phụ trách)
 	var classes []models.Class
-	result := config.DB.Where("course_id = ?", courseID).Find(&classes)
+	query := config.DB.Where("course_id = ?", courseID)
+	if claims, _ := c.Get("user").(jwt.MapClaims); claimString(claims, "role") != "admin" {
+		query = query.Where("lecturer_id = ?", claimString(claims, "user_id"))
+	}
+	result := query.Find(&classes)
 	if result.Error != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": result.Error.Error()})
 	}
@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Ngưỡng xét dự thi mặc định khi chính sách không đặt (phần trăm số buổi vắng quy đổi)
+const (
+	defaultWarningAbsencePercent = 15
+	defaultMaxAbsencePercent     = 20
+)
+
+// ExamEligibility là kết quả xét điều kiện dự thi của một sinh viên trong một lớp.
+type ExamEligibility struct {
+	ClassID           uuid.UUID `json:"class_id"`
+	ClassName         string    `json:"class_name"`
+	CourseID          uuid.UUID `json:"course_id"`
+	CourseName        string    `json:"course_name"`
+	StudentID         uuid.UUID `json:"student_id"`
+	StudentCode       string    `json:"student_code"`
+	FullName          string    `json:"full_name"`
+	TotalLesson       int       `json:"total_lesson"`
+	ScheduledSessions int       `json:"scheduled_sessions"` // Số buổi đã xếp lịch của lớp
+	HeldSessions      int       `json:"held_sessions"`      // Số buổi đã diễn ra
+	PresentCount      int       `json:"present_count"`
+	LateCount         int       `json:"late_count"`
+	AbsentCount       int       `json:"absent_count"`
+	ExcusedCount      int       `json:"excused_count"` // Vắng có phép, không tính vào tỉ lệ vắng
+	AbsenceUnits      float64   `json:"absence_units"` // Vắng tính 1, đi muộn tính theo late_absence_weight
+
+	PolicyWarningPercent *float64 `json:"-"`
+	PolicyMaxPercent     *float64 `json:"-"`
+
+	TotalSessions         int     `json:"total_sessions" gorm:"-"`        // Mẫu số: TotalLesson của khoá học, hoặc số buổi đã xếp lịch
+	TotalSessionsSource   string  `json:"total_sessions_source" gorm:"-"` // total_lesson hoặc schedules
+	AbsencePercent        float64 `json:"absence_percent" gorm:"-"`
+	WarningAbsencePercent float64 `json:"warning_absence_percent" gorm:"-"`
+	MaxAbsencePercent     float64 `json:"max_absence_percent" gorm:"-"`
+	AllowedAbsences       float64 `json:"allowed_absences" gorm:"-"`   // Số buổi vắng quy đổi tối đa vẫn được dự thi
+	RemainingAbsences     float64 `json:"remaining_absences" gorm:"-"` // Số buổi còn được vắng
+	Status                string  `json:"status" gorm:"-"`             // ok, warning, barred
+}
+
+// examThresholds trả về ngưỡng cảnh báo và cấm thi (%): ưu tiên chính sách, sau đó tới
+// EXAM_WARNING_ABSENCE_PERCENT / EXAM_MAX_ABSENCE_PERCENT.
+func examThresholds(policyWarning, policyMax *float64) (warningPct, maxPct float64) {
+	warningPct = float64(utils.GetEnvInt("EXAM_WARNING_ABSENCE_PERCENT", defaultWarningAbsencePercent))
+	maxPct = float64(utils.GetEnvInt("EXAM_MAX_ABSENCE_PERCENT", defaultMaxAbsencePercent))
+	if policyWarning != nil && *policyWarning > 0 {
+		warningPct = *policyWarning
+	}
+	if policyMax != nil && *policyMax > 0 {
+		maxPct = *policyMax
+	}
+	if warningPct > maxPct {
+		warningPct = maxPct
+	}
+	return warningPct, maxPct
+}
+
+// classify tính tỉ lệ vắng trên tổng số buổi của khoá học và xếp loại sinh viên.
+// Vắng vượt ngưỡng cấm thi (lớn hơn, không tính bằng) thì bị cấm thi.
+func (e *ExamEligibility) classify() {
+	e.WarningAbsencePercent, e.MaxAbsencePercent = examThresholds(e.PolicyWarningPercent, e.PolicyMaxPercent)
+
+	e.TotalSessions, e.TotalSessionsSource = e.TotalLesson, "total_lesson"
+	if e.TotalSessions <= 0 {
+		e.TotalSessions, e.TotalSessionsSource = e.ScheduledSessions, "schedules"
+	}
+	var percent float64
+	if e.TotalSessions > 0 {
+		percent = e.AbsenceUnits / float64(e.TotalSessions) * 100
+	}
+	allowed := e.MaxAbsencePercent * float64(e.TotalSessions) / 100
+	e.AbsencePercent = math.Round(percent*100) / 100
+	e.AllowedAbsences = math.Round(allowed*100) / 100
+	e.RemainingAbsences = math.Max(0, math.Round((allowed-e.AbsenceUnits)*100)/100)
+
+	switch {
+	case percent > e.MaxAbsencePercent:
+		e.Status = models.EligibilityBarred
+	case percent >= e.WarningAbsencePercent:
+		e.Status = models.EligibilityWarning
+	default:
+		e.Status = models.EligibilityOK
+	}
+}
+
+// loadExamEligibility xét điều kiện dự thi cho các sinh viên đang theo học thoả điều kiện where
+// (alias: c = classes, co = courses, cs = class_students), trong một câu truy vấn.
+func loadExamEligibility(db *gorm.DB, where string, args ...interface{}) ([]ExamEligibility, error) {
+	var results []ExamEligibility
+	err := db.Table("class_students cs").
+		Select(`c.class_id, c.class_name, co.course_id, co.course_name, co.total_lesson,
+			cs.student_id, st.student_code, u.first_name || ' ' || u.last_name AS full_name,
+			COUNT(s.schedule_id) AS scheduled_sessions,
+			COUNT(s.schedule_id) FILTER (WHERE s.start_time <= ?) AS held_sessions,
+			`+countStatusSQL("a", models.AttendanceStatusPresent)+` AS present_count,
+			`+countStatusSQL("a", models.AttendanceStatusLate)+` AS late_count,
+			`+countStatusSQL("a", models.AttendanceStatusAbsent)+` AS absent_count,
+			`+countStatusSQL("a", models.AttendanceStatusExcused)+` AS excused_count,
+			`+absenceUnitsSQL("a")+` AS absence_units,
+			pol.warning_absence_percent AS policy_warning_percent,
+			pol.max_absence_percent AS policy_max_percent`, time.Now()).
+		Joins("JOIN classes c ON c.class_id = cs.class_id").
+		Joins("JOIN courses co ON co.course_id = c.course_id").
+		Joins("JOIN students st ON st.student_id = cs.student_id").
+		Joins("JOIN users u ON u.user_id = cs.student_id").
+		Joins(policyJoinSQL("c")).
+		Joins("LEFT JOIN schedules s ON s.class_id = c.class_id").
+		Joins("LEFT JOIN attendance a ON a.schedule_id = s.schedule_id AND a.student_id = cs.student_id").
+		Where("COALESCE(cs.status, '') NOT IN ?", inactiveClassStudentStatuses).
+		Where(where, args...).
+		Group(`c.class_id, c.class_name, co.course_id, co.course_name, co.total_lesson,
+			cs.student_id, st.student_code, u.first_name, u.last_name,
+			pol.late_absence_weight, pol.warning_absence_percent, pol.max_absence_percent`).
+		Order("c.class_name, st.student_code").
+		Scan(&results).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].classify()
+	}
+	return results, nil
+}
+
+// eligibilityCounts đếm số sinh viên theo từng kết quả xét dự thi.
+func eligibilityCounts(results []ExamEligibility) map[string]int {
+	counts := map[string]int{models.EligibilityOK: 0, models.EligibilityWarning: 0, models.EligibilityBarred: 0}
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	return counts
+}
+
+// eligibilityStatusLabels là nhãn tiếng Việt của kết quả xét dự thi khi xuất file.
+var eligibilityStatusLabels = map[string]string{
+	models.EligibilityOK:      "Đủ điều kiện",
+	models.EligibilityWarning: "Cảnh báo",
+	models.EligibilityBarred:  "Cấm thi",
+}
+
+// writeEligibilityCSV ghi danh sách xét dự thi ra file CSV để giảng viên in và ký.
+func writeEligibilityCSV(c echo.Context, filename string, results []ExamEligibility) error {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	w.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	cw, err := utils.NewCSVWriter(w)
+	if err != nil {
+		return err
+	}
+	_ = cw.Write([]string{"STT", "Mã SV", "Họ và tên", "Lớp", "Học phần", "Có mặt", "Đi muộn", "Vắng",
+		"Vắng có phép", "Số buổi vắng quy đổi", "Tổng số buổi", "Tỉ lệ vắng (%)", "Kết quả"})
+	for i, r := range results {
+		_ = cw.Write([]string{
+			strconv.Itoa(i + 1),
+			utils.CSVText(r.StudentCode),
+			utils.CSVText(r.FullName),
+			utils.CSVText(r.ClassName),
+			utils.CSVText(r.CourseName),
+			strconv.Itoa(r.PresentCount),
+			strconv.Itoa(r.LateCount),
+			strconv.Itoa(r.AbsentCount),
+			strconv.Itoa(r.ExcusedCount),
+			strconv.FormatFloat(r.AbsenceUnits, 'f', -1, 64),
+			strconv.Itoa(r.TotalSessions),
+			strconv.FormatFloat(r.AbsencePercent, 'f', 2, 64),
+			eligibilityStatusLabels[r.Status],
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// GetClassExamEligibility trả về danh sách xét dự thi của một lớp kèm lần xác nhận gần nhất.
+// format=csv để tải file.
+func GetClassExamEligibility(c echo.Context) error {
+	_, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	results, err := loadExamEligibility(config.DB, "c.class_id = ?", *classID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to calculate exam eligibility"})
+	}
+	if c.QueryParam("format") == "csv" {
+		return writeEligibilityCSV(c, "exam-eligibility-"+classID.String()+".csv", results)
+	}
+
+	var signoffs []models.ExamEligibilitySignoff
+	if err := config.DB.Omit("students").Where("class_id = ?", *classID).
+		Order("created_at DESC").Limit(1).Find(&signoffs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve sign-off"})
+	}
+	var latest *models.ExamEligibilitySignoff
+	if len(signoffs) > 0 {
+		latest = &signoffs[0]
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"class_id":       classID,
+		"students":       results,
+		"counts":         eligibilityCounts(results),
+		"latest_signoff": latest,
+	})
+}
+
+// GetCourseExamEligibility trả về danh sách xét dự thi của tất cả các lớp thuộc khoá học.
+// format=csv để tải file.
+func GetCourseExamEligibility(c echo.Context) error {
+	courseID, _, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	results, err := loadExamEligibility(config.DB, "co.course_id = ?", *courseID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to calculate exam eligibility"})
+	}
+	if c.QueryParam("format") == "csv" {
+		return writeEligibilityCSV(c, "exam-eligibility-"+courseID.String()+".csv", results)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"course_id": courseID,
+		"students":  results,
+		"counts":    eligibilityCounts(results),
+	})
+}
+
+// SignOffExamEligibility lưu danh sách xét dự thi hiện tại của lớp như một bản đã được giảng viên xác nhận.
+func SignOffExamEligibility(c echo.Context) error {
+	_, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var input struct {
+		Note string `json:"note"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"error": "User not found"})
+	}
+
+	results, err := loadExamEligibility(config.DB, "c.class_id = ?", *classID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to calculate exam eligibility"})
+	}
+	students, err := json.Marshal(results)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record sign-off"})
+	}
+	counts := eligibilityCounts(results)
+
+	signoff := models.ExamEligibilitySignoff{
+		ClassID:       *classID,
+		SignedBy:      user.UserID,
+		Note:          input.Note,
+		TotalStudents: len(results),
+		WarningCount:  counts[models.EligibilityWarning],
+		BarredCount:   counts[models.EligibilityBarred],
+		Students:      students,
+	}
+	if err := config.DB.Create(&signoff).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record sign-off"})
+	}
+	return c.JSON(http.StatusCreated, signoff)
+}
+
+// GetExamEligibilitySignoffs trả về các lần xác nhận danh sách xét dự thi của lớp, mới nhất trước.
+func GetExamEligibilitySignoffs(c echo.Context) error {
+	_, classID, httpErr := policyScope(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var signoffs []models.ExamEligibilitySignoff
+	if err := config.DB.Where("class_id = ?", *classID).Order("created_at DESC").Find(&signoffs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve sign-offs"})
+	}
+	return c.JSON(http.StatusOK, signoffs)
+}
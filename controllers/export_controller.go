@@ -0,0 +1,281 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// attendanceStatusLabels là nhãn tiếng Việt của trạng thái điểm danh khi xuất file.
+var attendanceStatusLabels = map[string]string{
+	models.AttendanceStatusPresent: "Có mặt",
+	models.AttendanceStatusLate:    "Đi muộn",
+	models.AttendanceStatusAbsent:  "Vắng",
+	models.AttendanceStatusExcused: "Vắng có phép",
+}
+
+// exportFormat đọc tham số format (mặc định csv).
+func exportFormat(c echo.Context) (string, error) {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = utils.ExportCSV
+	}
+	switch format {
+	case utils.ExportCSV, utils.ExportXLSX, utils.ExportPDF:
+		return format, nil
+	}
+	return "", utils.ErrUnsupportedExportFormat
+}
+
+// exportMeta tạo phần thông tin đầu file: giảng viên, khoá học, lớp và thời điểm xuất.
+// courseID để trống thì lấy theo khoá học của lớp.
+func exportMeta(lecturerID, classID, courseID string, loc *time.Location) []utils.ExportMeta {
+	var lecturer, course, class string
+	config.DB.Raw(`SELECT first_name || ' ' || last_name FROM users WHERE user_id = ?`, lecturerID).Scan(&lecturer)
+	if classID != "" {
+		var row struct {
+			ClassName  string
+			CourseName string
+		}
+		config.DB.Raw(`SELECT c.class_name, co.course_name FROM classes c
+			JOIN courses co ON co.course_id = c.course_id WHERE c.class_id = ?`, classID).Scan(&row)
+		class = row.ClassName
+		if courseID == "" {
+			course = row.CourseName
+		}
+	}
+	if courseID != "" {
+		config.DB.Raw(`SELECT course_name FROM courses WHERE course_id = ?`, courseID).Scan(&course)
+	}
+	orAll := func(s string) string {
+		if s == "" {
+			return "Tất cả"
+		}
+		return s
+	}
+	return []utils.ExportMeta{
+		{Label: "Giảng viên", Value: lecturer},
+		{Label: "Học phần", Value: orAll(course)},
+		{Label: "Lớp", Value: orAll(class)},
+		{Label: "Ngày xuất", Value: time.Now().In(loc).Format("02/01/2006 15:04")},
+	}
+}
+
+// startExport đặt header tải file và tạo Exporter ghi thẳng ra response.
+func startExport(c echo.Context, format, name, title string, meta []utils.ExportMeta) (utils.Exporter, error) {
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, utils.ExportContentType(format))
+	w.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	return utils.NewExporter(format, w, title, meta)
+}
+
+// finishExport hoàn tất file. Nếu có lỗi khi chưa gửi dữ liệu nào thì trả về JSON như các API khác;
+// nếu response đã bắt đầu thì chỉ ghi log vì không thể đổi mã trạng thái nữa.
+func finishExport(c echo.Context, exporter utils.Exporter, err error) error {
+	if err == nil {
+		err = exporter.Close()
+	}
+	if err == nil {
+		return nil
+	}
+	if c.Response().Committed {
+		log.Printf("Error writing export %s: %v", c.Path(), err)
+		return nil
+	}
+	c.Response().Header().Del(echo.HeaderContentDisposition)
+	if errors.Is(err, utils.ErrExportTooLarge) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to export data"})
+}
+
+// attendanceRate là tỉ lệ đi học (%): có mặt và đi muộn trên tổng số buổi đã điểm danh.
+func attendanceRate(present, late, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(present+late)*10000/float64(total)) / 100
+}
+
+// studentAttendanceTotals là số buổi theo trạng thái của một sinh viên trong file xuất chi tiết.
+type studentAttendanceTotals struct {
+	StudentCode string
+	FullName    string
+	Counts      map[string]int
+	Total       int
+}
+
+// ExportAttendanceDetails xuất chi tiết điểm danh (cùng bộ lọc với /attendance-detail) ra CSV, XLSX hoặc PDF,
+// kèm bảng tổng hợp theo sinh viên. Dữ liệu được đọc và ghi theo từng dòng. Không xuất ảnh minh chứng.
+func ExportAttendanceDetails(c echo.Context) error {
+	lecturerID := c.QueryParam("lecturer_id")
+	if lecturerID == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "lecturer_id is required"})
+	}
+	classID := c.QueryParam("class_id")
+	scheduleID := c.QueryParam("schedule_id")
+	format, err := exportFormat(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	loc, err := reportLocation(c.QueryParam("tz"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid tz"})
+	}
+
+	rows, err := attendanceDetailQuery(lecturerID, classID, scheduleID).
+		Order("st.student_code, s.start_time").Rows()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve data"})
+	}
+	defer rows.Close()
+
+	meta := exportMeta(lecturerID, classID, "", loc)
+	exporter, err := startExport(c, format, "attendance-detail-"+time.Now().In(loc).Format("20060102"), "Chi tiết điểm danh", meta)
+	if err != nil {
+		return finishExport(c, exporter, err)
+	}
+	err = exporter.Table("Chi tiết điểm danh", []utils.ExportColumn{
+		{Title: "STT", Width: 6}, {Title: "Mã SV", Width: 12}, {Title: "Họ và tên", Width: 26},
+		{Title: "Lớp", Width: 16}, {Title: "Học phần", Width: 24}, {Title: "Ngày học", Width: 16},
+		{Title: "Giờ điểm danh", Width: 16}, {Title: "Trạng thái", Width: 13}, {Title: "Ghi chú", Width: 28},
+	})
+
+	var totals []*studentAttendanceTotals
+	byStudent := map[uuid.UUID]*studentAttendanceTotals{}
+	for n := 1; err == nil && rows.Next(); n++ {
+		var r struct {
+			StudentID      uuid.UUID
+			StudentCode    string
+			FirstName      string
+			LastName       string
+			ClassName      string
+			CourseName     string
+			Status         string
+			AttendanceTime *time.Time
+			StartTime      time.Time
+			Note           *string
+		}
+		if err = config.DB.ScanRows(rows, &r); err != nil {
+			break
+		}
+		fullName := r.FirstName + " " + r.LastName
+		attendedAt, note := "", ""
+		if r.AttendanceTime != nil && !r.AttendanceTime.IsZero() {
+			attendedAt = r.AttendanceTime.In(loc).Format("02/01/2006 15:04")
+		}
+		if r.Note != nil {
+			note = *r.Note
+		}
+		status := attendanceStatusLabels[r.Status]
+		if status == "" {
+			status = r.Status
+		}
+		err = exporter.Row(n, r.StudentCode, fullName, r.ClassName, r.CourseName,
+			r.StartTime.In(loc).Format("02/01/2006 15:04"), attendedAt, status, note)
+
+		t := byStudent[r.StudentID]
+		if t == nil {
+			t = &studentAttendanceTotals{StudentCode: r.StudentCode, FullName: fullName, Counts: map[string]int{}}
+			byStudent[r.StudentID] = t
+			totals = append(totals, t)
+		}
+		t.Counts[r.Status]++
+		t.Total++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = exporter.Table("Tổng hợp theo sinh viên", []utils.ExportColumn{
+			{Title: "STT", Width: 6}, {Title: "Mã SV", Width: 12}, {Title: "Họ và tên", Width: 26},
+			{Title: "Số buổi", Width: 10}, {Title: "Có mặt", Width: 10}, {Title: "Đi muộn", Width: 10},
+			{Title: "Vắng", Width: 10}, {Title: "Vắng có phép", Width: 13}, {Title: "Tỉ lệ đi học (%)", Width: 15},
+		})
+	}
+	for i, t := range totals {
+		if err != nil {
+			break
+		}
+		present, late := t.Counts[models.AttendanceStatusPresent], t.Counts[models.AttendanceStatusLate]
+		err = exporter.Row(i+1, t.StudentCode, t.FullName, t.Total, present, late,
+			t.Counts[models.AttendanceStatusAbsent], t.Counts[models.AttendanceStatusExcused],
+			attendanceRate(present, late, t.Total))
+	}
+	return finishExport(c, exporter, err)
+}
+
+// ExportStudentAttendanceSummary xuất tổng hợp điểm danh theo sinh viên (cùng bộ lọc với
+// /student-attendance-summary/:lecturer_id) ra CSV, XLSX hoặc PDF, kèm dòng tổng cộng.
+func ExportStudentAttendanceSummary(c echo.Context) error {
+	lecturerID := c.Param("lecturer_id")
+	classID := c.QueryParam("class_id")
+	courseID := c.QueryParam("course_id")
+	format, err := exportFormat(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	loc, err := reportLocation(c.QueryParam("tz"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid tz"})
+	}
+
+	var results []struct {
+		StudentCode    string  `gorm:"column:studentCode"`
+		FullName       string  `gorm:"column:fullName"`
+		AttendanceDays int     `gorm:"column:attendanceDays"`
+		PresentDays    int     `gorm:"column:presentDays"`
+		LateDays       int     `gorm:"column:lateDays"`
+		AbsentDays     int     `gorm:"column:absentDays"`
+		ExcusedDays    int     `gorm:"column:excusedDays"`
+		AbsenceUnits   float64 `gorm:"column:absenceUnits"`
+	}
+	query, params := studentAttendanceSummaryQuery(lecturerID, classID, courseID)
+	if err := config.DB.Raw(query, params...).Scan(&results).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch data"})
+	}
+	// Lớp chưa có dữ liệu điểm danh: vẫn xuất danh sách sinh viên như API tổng hợp
+	if len(results) == 0 && classID != "" {
+		query, params = enrolledStudentsSummaryQuery(lecturerID, classID, courseID)
+		if err := config.DB.Raw(query, params...).Scan(&results).Error; err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to fetch fallback data"})
+		}
+	}
+
+	meta := exportMeta(lecturerID, classID, courseID, loc)
+	exporter, err := startExport(c, format, "attendance-summary-"+time.Now().In(loc).Format("20060102"), "Tổng hợp điểm danh", meta)
+	if err != nil {
+		return finishExport(c, exporter, err)
+	}
+	err = exporter.Table("Tổng hợp điểm danh", []utils.ExportColumn{
+		{Title: "STT", Width: 6}, {Title: "Mã SV", Width: 12}, {Title: "Họ và tên", Width: 26},
+		{Title: "Số buổi", Width: 10}, {Title: "Có mặt", Width: 10}, {Title: "Đi muộn", Width: 10},
+		{Title: "Vắng", Width: 10}, {Title: "Vắng có phép", Width: 13}, {Title: "Số buổi vắng quy đổi", Width: 18},
+		{Title: "Tỉ lệ đi học (%)", Width: 15},
+	})
+	var total, present, late, absent, excused int
+	var units float64
+	for i, r := range results {
+		if err != nil {
+			break
+		}
+		err = exporter.Row(i+1, r.StudentCode, r.FullName, r.AttendanceDays, r.PresentDays, r.LateDays,
+			r.AbsentDays, r.ExcusedDays, r.AbsenceUnits, attendanceRate(r.PresentDays, r.LateDays, r.AttendanceDays))
+		total, present, late = total+r.AttendanceDays, present+r.PresentDays, late+r.LateDays
+		absent, excused, units = absent+r.AbsentDays, excused+r.ExcusedDays, units+r.AbsenceUnits
+	}
+	if err == nil {
+		err = exporter.Row("", "", "Tổng cộng", total, present, late, absent, excused, units,
+			attendanceRate(present, late, total))
+	}
+	return finishExport(c, exporter, err)
+}
@@ -0,0 +1,207 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+const (
+	faceEmbeddingDim          = 512 // Số chiều của cột face_embedding cũ
+	defaultFaceTopK           = 5
+	maxFaceTopK               = 50
+	defaultFaceMatchThreshold = 0.4
+)
+
+// FaceMatch là một ứng viên trả về khi nhận dạng khuôn mặt 1:N.
+type FaceMatch struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Role        string    `json:"role"`
+	StudentCode *string   `json:"student_code"`
+	FirstName   string    `json:"first_name"`
+	LastName    string    `json:"last_name"`
+	Distance    float64   `json:"distance"`   // Khoảng cách cosine (0 = giống hệt)
+	Similarity  float64   `json:"similarity"` // 1 - distance
+	Accepted    bool      `json:"accepted"`   // distance <= threshold
+}
+
+// faceCandidateFilter giới hạn tập ứng viên về sinh viên của một buổi học hoặc một lớp.
+type faceCandidateFilter struct {
+	ScheduleID string
+	ClassID    string
+}
+
+// faceMatchThreshold đọc ngưỡng chấp nhận (khoảng cách cosine) từ FACE_MATCH_THRESHOLD.
+func faceMatchThreshold() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("FACE_MATCH_THRESHOLD"), 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultFaceMatchThreshold
+}
+
+// identifyFaces tìm topK người có embedding gần nhất với embedding đầu vào theo khoảng cách cosine.
+// Mỗi người được so khớp với tất cả embedding đã đăng ký của cùng mô hình, lấy khoảng cách nhỏ nhất.
+func identifyFaces(embedding []float32, model models.FaceModel, filter faceCandidateFilter, topK int, threshold float64) ([]FaceMatch, error) {
+	params := map[string]interface{}{
+		"embedding":     pgvector.NewVector(embedding),
+		"model_name":    model.ModelName,
+		"model_version": model.ModelVersion,
+		"dimension":     model.Dimension,
+		"top_k":         topK,
+	}
+
+	// Khi có schedule_id/class_id chỉ so khớp với sinh viên đã ghi danh vào lớp đó,
+	// nếu không thì so khớp với mọi sinh viên (không bao giờ gồm tài khoản giảng viên/admin)
+	var candidates string
+	switch {
+	case filter.ScheduleID != "":
+		candidates = `
+			SELECT cs.student_id AS user_id
+			FROM class_students cs
+			JOIN schedules sc ON sc.class_id = cs.class_id
+			WHERE sc.schedule_id = @schedule_id`
+		params["schedule_id"] = filter.ScheduleID
+	case filter.ClassID != "":
+		candidates = `
+			SELECT cs.student_id AS user_id
+			FROM class_students cs
+			WHERE cs.class_id = @class_id`
+		params["class_id"] = filter.ClassID
+	default:
+		candidates = `SELECT student_id AS user_id FROM students`
+	}
+
+	query := `
+		WITH candidates AS (` + candidates + `
+		),
+		scored AS (
+			SELECT fe.user_id, MIN(fe.embedding <=> @embedding) AS distance
+			FROM face_embeddings fe
+			JOIN candidates c ON c.user_id = fe.user_id
+			WHERE fe.model_name = @model_name
+			  AND fe.model_version = @model_version
+			  AND fe.dimension = @dimension
+			GROUP BY fe.user_id
+		)
+		SELECT s.user_id, u.role, st.student_code, u.first_name, u.last_name, s.distance
+		FROM scored s
+		JOIN users u ON u.user_id = s.user_id
+		LEFT JOIN students st ON st.student_id = s.user_id
+		ORDER BY s.distance
+		LIMIT @top_k`
+
+	var matches []FaceMatch
+	if err := config.DB.Raw(query, params).Scan(&matches).Error; err != nil {
+		return nil, err
+	}
+	for i := range matches {
+		matches[i].Similarity = 1 - matches[i].Distance
+		matches[i].Accepted = matches[i].Distance <= threshold
+	}
+	return matches, nil
+}
+
+// IdentifyFace nhận một embedding khuôn mặt và trả về top-k sinh viên khớp nhất.
+// Dịch vụ camera có thể tìm trên toàn bộ sinh viên; người dùng khác phải truyền schedule_id hoặc class_id
+// của lớp mình phụ trách. threshold chỉ được chặt hơn FACE_MATCH_THRESHOLD.
+func IdentifyFace(c echo.Context) error {
+	var input struct {
+		Embedding    []float32 `json:"embedding"`
+		ModelName    string    `json:"model_name"`    // Mặc định: mô hình đang hoạt động
+		ModelVersion string    `json:"model_version"` // Mặc định: mô hình đang hoạt động
+		ScheduleID   string    `json:"schedule_id"`
+		ClassID      string    `json:"class_id"`
+		TopK         int       `json:"top_k"`
+		Threshold    *float64  `json:"threshold"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+
+	model, httpErr := resolveFaceModel(input.ModelName, input.ModelVersion)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	if len(input.Embedding) != model.Dimension {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "embedding must have " + strconv.Itoa(model.Dimension) + " dimensions"})
+	}
+	var classIDs []uuid.UUID
+	if input.ClassID != "" {
+		classID, err := uuid.Parse(input.ClassID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid schedule_id or class_id"})
+		}
+		classIDs = append(classIDs, classID)
+	}
+	if input.ScheduleID != "" {
+		var schedule models.Schedule
+		if _, err := uuid.Parse(input.ScheduleID); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid schedule_id or class_id"})
+		}
+		if err := config.DB.First(&schedule, "schedule_id = ?", input.ScheduleID).Error; err != nil {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Schedule not found"})
+		}
+		classIDs = append(classIDs, schedule.ClassID)
+	}
+
+	// Chỉ dịch vụ camera được nhận dạng trên toàn bộ sinh viên
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	if claimString(claims, "role") != "service" {
+		if len(classIDs) == 0 {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "schedule_id or class_id is required"})
+		}
+		for _, classID := range classIDs {
+			if httpErr := requireClassReviewer(c, classID); httpErr != nil {
+				return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+			}
+		}
+	}
+
+	topK := input.TopK
+	if topK <= 0 {
+		topK = defaultFaceTopK
+	}
+	if topK > maxFaceTopK {
+		topK = maxFaceTopK
+	}
+	// Ngưỡng từ request chỉ được chặt hơn ngưỡng cấu hình
+	threshold := faceMatchThreshold()
+	if input.Threshold != nil && *input.Threshold < threshold {
+		threshold = *input.Threshold
+	}
+
+	matches, err := identifyFaces(input.Embedding, model, faceCandidateFilter{
+		ScheduleID: input.ScheduleID,
+		ClassID:    input.ClassID,
+	}, topK, threshold)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to identify face"})
+	}
+
+	// Mọi người có embedding được trả về đều được ghi vào log truy cập
+	accesses := make([]biometricAccess, 0, len(matches))
+	for _, m := range matches {
+		accesses = append(accesses, biometricAccess{SubjectUserID: m.UserID})
+	}
+	logBiometricAccess(c, "face_embedding", "identify", accesses)
+
+	// Người khớp nhất nếu vượt ngưỡng chấp nhận
+	var best *FaceMatch
+	if len(matches) > 0 && matches[0].Accepted {
+		best = &matches[0]
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"model":     model,
+		"threshold": threshold,
+		"match":     best,
+		"matches":   matches,
+	})
+}
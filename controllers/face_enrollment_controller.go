@@ -0,0 +1,299 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	pgvector "github.com/pgvector/pgvector-go"
+	"gorm.io/gorm"
+)
+
+// Sai số cho phép khi kiểm tra embedding đã được chuẩn hoá L2 (‖v‖ = 1)
+const faceEmbeddingNormTolerance = 0.01
+
+// validateFaceEmbedding từ chối embedding sai số chiều so với mô hình, vector 0 hoặc chưa chuẩn hoá.
+func validateFaceEmbedding(embedding []float32, dimension int) error {
+	if len(embedding) != dimension {
+		return fmt.Errorf("embedding must have %d dimensions", dimension)
+	}
+
+	var sum float64
+	for _, v := range embedding {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return errors.New("embedding contains invalid values")
+		}
+		sum += float64(v) * float64(v)
+	}
+	norm := math.Sqrt(sum)
+	if norm == 0 {
+		return errors.New("embedding must not be a zero vector")
+	}
+	if math.Abs(norm-1) > faceEmbeddingNormTolerance {
+		return fmt.Errorf("embedding must be L2-normalized (norm = %.4f)", norm)
+	}
+	return nil
+}
+
+// syncPrimaryFaceEmbedding chép embedding chính vào cột face_embedding của bảng hồ sơ
+// (students/lecturers/admins) để tương thích với các dịch vụ vẫn đọc cột này.
+// Cột cũ có kiểu vector(512) nên chỉ nhận embedding 512 chiều, ưu tiên mô hình đang hoạt động.
+func syncPrimaryFaceEmbedding(tx *gorm.DB, user models.User) error {
+	var primary models.FaceEmbedding
+	err := tx.Where("user_id = ? AND dimension = ?", user.UserID, faceEmbeddingDim).
+		Order(`EXISTS (SELECT 1 FROM face_models fm WHERE fm.is_active
+		       AND fm.model_name = face_embeddings.model_name
+		       AND fm.model_version = face_embeddings.model_version) DESC`).
+		Order("is_primary DESC, created_at DESC").
+		First(&primary).Error
+
+	var value interface{}
+	switch {
+	case err == nil:
+		value = primary.Embedding
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		value = nil
+	default:
+		return err
+	}
+
+	switch user.Role {
+	case "student":
+		return tx.Model(&models.Student{}).Where("student_id = ?", user.UserID).Update("face_embedding", value).Error
+	case "lecturer":
+		return tx.Model(&models.Lecturer{}).Where("lecturer_id = ?", user.UserID).Update("face_embedding", value).Error
+	case "admin":
+		return tx.Model(&models.Admin{}).Where("admin_id = ?", user.UserID).Update("face_embedding", value).Error
+	}
+	return nil
+}
+
+// MigrateLegacyFaceEmbeddings chuyển các embedding (khác 0) đang nằm ở cột face_embedding
+// của students/lecturers/admins sang bảng face_embeddings. Chạy lại nhiều lần không tạo bản sao.
+// Không rõ mô hình nào đã sinh ra các embedding này nên chúng được gắn model "unknown".
+func MigrateLegacyFaceEmbeddings() error {
+	for _, table := range []struct{ name, idColumn string }{
+		{"students", "student_id"},
+		{"lecturers", "lecturer_id"},
+		{"admins", "admin_id"},
+	} {
+		query := `
+			INSERT INTO face_embeddings (user_id, embedding, model_name, model_version, dimension, source, is_primary, created_at)
+			SELECT t.` + table.idColumn + `, t.face_embedding, 'unknown', 'unknown', 512, 'legacy', true, NOW()
+			FROM ` + table.name + ` t
+			WHERE t.face_embedding IS NOT NULL
+			  AND vector_norm(t.face_embedding) > 0
+			  AND NOT EXISTS (SELECT 1 FROM face_embeddings fe WHERE fe.user_id = t.` + table.idColumn + `)`
+		if err := config.DB.Exec(query).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadEnrollmentUser đọc user theo tham số :user_id.
+func loadEnrollmentUser(c echo.Context) (models.User, *echo.HTTPError) {
+	var user models.User
+	userID, err := uuid.Parse(c.Param("user_id"))
+	if err != nil {
+		return user, echo.NewHTTPError(http.StatusBadRequest, "Invalid user_id")
+	}
+	if err := config.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+		return user, echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// AddFaceEmbedding đăng ký thêm một embedding khuôn mặt cho user.
+func AddFaceEmbedding(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var input struct {
+		Embedding    []float32 `json:"embedding"`
+		ModelName    string    `json:"model_name"`    // Mặc định: mô hình đang hoạt động
+		ModelVersion string    `json:"model_version"` // Mặc định: mô hình đang hoạt động
+		Source       string    `json:"source"`
+		QualityScore float64   `json:"quality_score"`
+		IsPrimary    bool      `json:"is_primary"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	model, httpErr := resolveFaceModel(input.ModelName, input.ModelVersion)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	if err := validateFaceEmbedding(input.Embedding, model.Dimension); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	}
+	if input.Source == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "source is required"})
+	}
+	if input.QualityScore < 0 || input.QualityScore > 1 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "quality_score must be between 0 and 1"})
+	}
+
+	// Chỉ thu thập dữ liệu khuôn mặt khi người đó đã đồng ý
+	consented, err := hasBiometricConsent(config.DB, user.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check biometric consent"})
+	}
+	if !consented {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "User has not consented to biometric data processing"})
+	}
+
+	record := models.FaceEmbedding{
+		UserID:       user.UserID,
+		Embedding:    pgvector.NewVector(input.Embedding),
+		ModelName:    model.ModelName,
+		ModelVersion: model.ModelVersion,
+		Dimension:    model.Dimension,
+		Source:       input.Source,
+		QualityScore: input.QualityScore,
+		IsPrimary:    input.IsPrimary,
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		sameModel := tx.Model(&models.FaceEmbedding{}).
+			Where("user_id = ? AND model_name = ? AND model_version = ?", user.UserID, model.ModelName, model.ModelVersion).
+			Session(&gorm.Session{})
+
+		// Embedding đầu tiên của một người trong mỗi mô hình luôn là embedding chính
+		var count int64
+		if err := sameModel.Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			record.IsPrimary = true
+		}
+		if record.IsPrimary {
+			if err := sameModel.Update("is_primary", false).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		return syncPrimaryFaceEmbedding(tx, user)
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to save face embedding"})
+	}
+
+	return c.JSON(http.StatusCreated, record)
+}
+
+// GetFaceEmbeddings liệt kê các embedding đã đăng ký của user (không trả về giá trị vector).
+func GetFaceEmbeddings(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	var embeddings []models.FaceEmbedding
+	if err := config.DB.Omit("embedding").
+		Where("user_id = ?", user.UserID).
+		Order("is_primary DESC, created_at DESC").
+		Find(&embeddings).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve face embeddings"})
+	}
+
+	accesses := make([]biometricAccess, 0, len(embeddings))
+	for _, e := range embeddings {
+		accesses = append(accesses, biometricAccess{SubjectUserID: user.UserID, ResourceID: e.EmbeddingID.String()})
+	}
+	logBiometricAccess(c, "face_embedding", "list", accesses)
+
+	return c.JSON(http.StatusOK, embeddings)
+}
+
+// DeleteFaceEmbedding xoá một embedding; nếu là embedding chính thì embedding mới nhất còn lại được chọn thay.
+func DeleteFaceEmbedding(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	embeddingID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid embedding ID"})
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var record models.FaceEmbedding
+		if err := tx.Omit("embedding").First(&record, "embedding_id = ? AND user_id = ?", embeddingID, user.UserID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.FaceEmbedding{}, "embedding_id = ?", embeddingID).Error; err != nil {
+			return err
+		}
+
+		if record.IsPrimary {
+			var next models.FaceEmbedding
+			err := tx.Omit("embedding").
+				Where("user_id = ? AND model_name = ? AND model_version = ?", user.UserID, record.ModelName, record.ModelVersion).
+				Order("created_at DESC").
+				First(&next).Error
+			if err == nil {
+				if err := tx.Model(&models.FaceEmbedding{}).Where("embedding_id = ?", next.EmbeddingID).Update("is_primary", true).Error; err != nil {
+					return err
+				}
+			} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+		return syncPrimaryFaceEmbedding(tx, user)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Face embedding not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to delete face embedding"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Face embedding deleted successfully"})
+}
+
+// SetPrimaryFaceEmbedding đặt một embedding làm embedding chính của user.
+func SetPrimaryFaceEmbedding(c echo.Context) error {
+	user, httpErr := loadEnrollmentUser(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	embeddingID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid embedding ID"})
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var record models.FaceEmbedding
+		if err := tx.Omit("embedding").First(&record, "embedding_id = ? AND user_id = ?", embeddingID, user.UserID).Error; err != nil {
+			return err
+		}
+
+		// Embedding chính được xác định riêng cho từng mô hình
+		if err := tx.Model(&models.FaceEmbedding{}).
+			Where("user_id = ? AND model_name = ? AND model_version = ?", user.UserID, record.ModelName, record.ModelVersion).
+			Update("is_primary", gorm.Expr("embedding_id = ?", embeddingID)).Error; err != nil {
+			return err
+		}
+		return syncPrimaryFaceEmbedding(tx, user)
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Face embedding not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to set primary face embedding"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"message": "Primary face embedding updated successfully"})
+}
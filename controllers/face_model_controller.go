@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+var errNoActiveFaceModel = errors.New("no active face model")
+
+// activeFaceModel trả về mô hình nhận dạng đang hoạt động.
+func activeFaceModel() (models.FaceModel, error) {
+	var model models.FaceModel
+	err := config.DB.First(&model, "is_active = ?", true).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model, errNoActiveFaceModel
+	}
+	return model, err
+}
+
+// resolveFaceModel tìm mô hình theo tên/phiên bản; nếu không truyền thì dùng mô hình đang hoạt động.
+func resolveFaceModel(name, version string) (models.FaceModel, *echo.HTTPError) {
+	if name == "" && version == "" {
+		model, err := activeFaceModel()
+		if errors.Is(err, errNoActiveFaceModel) {
+			return model, echo.NewHTTPError(http.StatusConflict, "No active face model configured")
+		}
+		if err != nil {
+			return model, echo.NewHTTPError(http.StatusInternalServerError, "Failed to load face model")
+		}
+		return model, nil
+	}
+
+	var model models.FaceModel
+	if err := config.DB.First(&model, "model_name = ? AND model_version = ?", name, version).Error; err != nil {
+		return model, echo.NewHTTPError(http.StatusBadRequest, "Unknown face model "+name+" "+version)
+	}
+	return model, nil
+}
+
+// GetFaceModels liệt kê các mô hình nhận dạng đã đăng ký.
+func GetFaceModels(c echo.Context) error {
+	var faceModels []models.FaceModel
+	if err := config.DB.Order("created_at DESC").Find(&faceModels).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve face models"})
+	}
+	return c.JSON(http.StatusOK, faceModels)
+}
+
+// CreateFaceModel đăng ký một mô hình nhận dạng mới (chưa kích hoạt).
+func CreateFaceModel(c echo.Context) error {
+	var input struct {
+		ModelName    string `json:"model_name"`
+		ModelVersion string `json:"model_version"`
+		Dimension    int    `json:"dimension"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	if input.ModelName == "" || input.ModelVersion == "" || input.Dimension <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "model_name, model_version and dimension are required"})
+	}
+
+	model := models.FaceModel{
+		ModelName:    input.ModelName,
+		ModelVersion: input.ModelVersion,
+		Dimension:    input.Dimension,
+	}
+	if err := config.DB.Create(&model).Error; err != nil {
+		return c.JSON(http.StatusConflict, echo.Map{"error": "Face model already exists"})
+	}
+	return c.JSON(http.StatusCreated, model)
+}
+
+// ActivateFaceModel chuyển mô hình đang hoạt động sang mô hình được chọn.
+func ActivateFaceModel(c echo.Context) error {
+	modelID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid face model ID"})
+	}
+
+	var model models.FaceModel
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model, "face_model_id = ?", modelID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.FaceModel{}).Where("is_active = ?", true).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		model.IsActive = true
+		return tx.Model(&model).Update("is_active", true).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": "Face model not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to activate face model"})
+	}
+	return c.JSON(http.StatusOK, model)
+}
+
+// GetReenrollmentStatus liệt kê những người chưa có embedding cho mô hình đang hoạt động
+// (hoặc mô hình chỉ định qua model_name/model_version), lọc theo role hoặc class_id.
+func GetReenrollmentStatus(c echo.Context) error {
+	model, httpErr := resolveFaceModel(c.QueryParam("model_name"), c.QueryParam("model_version"))
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	type MissingEnrollment struct {
+		UserID      uuid.UUID `json:"user_id"`
+		Role        string    `json:"role"`
+		StudentCode *string   `json:"student_code"`
+		FirstName   string    `json:"first_name"`
+		LastName    string    `json:"last_name"`
+		OtherModels *string   `json:"other_models"` // Các mô hình cũ mà người này đã có embedding
+	}
+
+	query := config.DB.Table("users u").
+		Select(`u.user_id, u.role, st.student_code, u.first_name, u.last_name,
+		        (SELECT STRING_AGG(DISTINCT fe.model_name || ' ' || fe.model_version, ', ')
+		         FROM face_embeddings fe WHERE fe.user_id = u.user_id) AS other_models`).
+		Joins("LEFT JOIN students st ON st.student_id = u.user_id").
+		Where("u.role IN ?", []string{"student", "lecturer", "admin"}).
+		Where(`NOT EXISTS (SELECT 1 FROM face_embeddings fe
+		                   WHERE fe.user_id = u.user_id AND fe.model_name = ? AND fe.model_version = ?)`,
+			model.ModelName, model.ModelVersion)
+
+	if role := c.QueryParam("role"); role != "" {
+		query = query.Where("u.role = ?", role)
+	}
+	if classID := c.QueryParam("class_id"); classID != "" {
+		query = query.Where("u.user_id IN (SELECT student_id FROM class_students WHERE class_id = ?)", classID)
+	}
+
+	var missing []MissingEnrollment
+	if err := query.Order("u.role, u.last_name, u.first_name").Scan(&missing).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve re-enrollment status"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"model":         model,
+		"missing_count": len(missing),
+		"missing":       missing,
+	})
+}
@@ -0,0 +1,423 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	maxLeaveSchedules     = 20
+	maxLeaveDocuments     = 5
+	maxLeaveDocumentBytes = 5 << 20
+)
+
+// leaveDocumentTypes là các kiểu tài liệu đính kèm được chấp nhận.
+var leaveDocumentTypes = []string{"application/pdf", "image/jpeg", "image/png"}
+
+// leaveRequestView bổ sung thông tin sinh viên cho hàng đợi duyệt đơn.
+type leaveRequestView struct {
+	models.LeaveRequest
+	StudentCode string `json:"student_code"`
+	FullName    string `json:"full_name"`
+}
+
+// canReviewClass cho biết user (admin hoặc giảng viên phụ trách lớp) có quyền duyệt đơn của lớp hay không.
+func canReviewClass(claims jwt.MapClaims, classID uuid.UUID) (bool, error) {
+	switch claimString(claims, "role") {
+	case "admin":
+		return true, nil
+	case "lecturer":
+		var count int64
+		err := config.DB.Model(&models.Class{}).
+			Where("class_id = ? AND lecturer_id = ?", classID, claimString(claims, "user_id")).
+			Count(&count).Error
+		return count > 0, err
+	}
+	return false, nil
+}
+
+// requireClassReviewer trả về lỗi 403 nếu người gọi không phụ trách lớp classID (admin luôn được phép).
+func requireClassReviewer(c echo.Context, classID uuid.UUID) *echo.HTTPError {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	allowed, err := canReviewClass(claims, classID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check permission")
+	}
+	if !allowed {
+		return echo.NewHTTPError(http.StatusForbidden, "You do not teach this class")
+	}
+	return nil
+}
+
+// leaveScheduleIDs đọc danh sách schedule_ids từ form (lặp lại hoặc phân tách bằng dấu phẩy).
+func leaveScheduleIDs(values []string) ([]uuid.UUID, error) {
+	seen := map[uuid.UUID]bool{}
+	var ids []uuid.UUID
+	for _, value := range values {
+		for _, raw := range strings.Split(value, ",") {
+			raw = strings.TrimSpace(raw)
+			if raw == "" {
+				continue
+			}
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// SubmitLeaveRequest cho phép sinh viên gửi đơn xin nghỉ (multipart/form-data):
+// reason, schedule_ids (các buổi của cùng một lớp) và tối đa 5 tài liệu "documents" (PDF, JPEG, PNG).
+func SubmitLeaveRequest(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	studentID, err := uuid.Parse(claimString(claims, "user_id"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
+	}
+
+	reason := strings.TrimSpace(c.FormValue("reason"))
+	if reason == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "reason is required"})
+	}
+
+	var files []*multipart.FileHeader
+	var rawScheduleIDs []string
+	if form, err := c.MultipartForm(); err == nil {
+		files = form.File["documents"]
+		rawScheduleIDs = form.Value["schedule_ids"]
+	} else {
+		rawScheduleIDs = c.Request().Form["schedule_ids"]
+	}
+	scheduleIDs, err := leaveScheduleIDs(rawScheduleIDs)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid schedule_ids"})
+	}
+	if len(scheduleIDs) == 0 || len(scheduleIDs) > maxLeaveSchedules {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "schedule_ids must contain between 1 and 20 schedules"})
+	}
+	if len(files) > maxLeaveDocuments {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Too many documents"})
+	}
+
+	// Các buổi học phải tồn tại, cùng một lớp và sinh viên phải thuộc lớp đó
+	var schedules []models.Schedule
+	if err := config.DB.Where("schedule_id IN ?", scheduleIDs).Find(&schedules).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve schedules"})
+	}
+	if len(schedules) != len(scheduleIDs) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Schedule not found"})
+	}
+	classID := schedules[0].ClassID
+	for _, s := range schedules {
+		if s.ClassID != classID {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "All schedules must belong to the same class"})
+		}
+	}
+	enrolled, err := isEnrolled(classID, studentID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check enrollment"})
+	}
+	if !enrolled {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "You are not enrolled in this class"})
+	}
+
+	// Không cho gửi trùng buổi đã có đơn đang chờ hoặc đã duyệt
+	var overlapping int64
+	err = config.DB.Model(&models.LeaveRequestSchedule{}).
+		Joins("JOIN leave_requests lr ON lr.request_id = leave_request_schedules.request_id").
+		Where("lr.student_id = ? AND lr.status IN ? AND leave_request_schedules.schedule_id IN ?",
+			studentID, []string{models.LeaveStatusPending, models.LeaveStatusApproved}, scheduleIDs).
+		Count(&overlapping).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check existing leave requests"})
+	}
+	if overlapping > 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A leave request already exists for one of these schedules"})
+	}
+
+	request := models.LeaveRequest{
+		RequestID: uuid.New(),
+		StudentID: studentID,
+		ClassID:   classID,
+		Reason:    reason,
+		Status:    models.LeaveStatusPending,
+	}
+	for _, id := range scheduleIDs {
+		request.Schedules = append(request.Schedules, models.LeaveRequestSchedule{RequestID: request.RequestID, ScheduleID: id})
+	}
+
+	var stored []utils.StoredFile
+	removeStored := func() {
+		for _, f := range stored {
+			if err := utils.RemoveUpload(f.Path); err != nil {
+				log.Printf("Error removing leave document %s: %v", f.Path, err)
+			}
+		}
+	}
+	for _, fh := range files {
+		f, err := utils.SaveUpload(fh, "leave-requests", leaveDocumentTypes, maxLeaveDocumentBytes)
+		if err != nil {
+			removeStored()
+			switch {
+			case errors.Is(err, utils.ErrFileTooLarge):
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Document must not exceed 5MB"})
+			case errors.Is(err, utils.ErrUnsupportedFileType):
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "Documents must be PDF, JPEG or PNG"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save document"})
+		}
+		stored = append(stored, f)
+		request.Documents = append(request.Documents, models.LeaveRequestDocument{
+			DocumentID:  uuid.New(),
+			RequestID:   request.RequestID,
+			FileName:    f.FileName,
+			ContentType: f.ContentType,
+			Size:        f.Size,
+			StoragePath: f.Path,
+		})
+	}
+
+	if err := config.DB.Create(&request).Error; err != nil {
+		removeStored()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create leave request"})
+	}
+
+	return c.JSON(http.StatusCreated, request)
+}
+
+// GetMyLeaveRequests trả về các đơn xin nghỉ của sinh viên đang đăng nhập.
+func GetMyLeaveRequests(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+
+	var requests []models.LeaveRequest
+	err := config.DB.Preload("Schedules").Preload("Documents").
+		Where("student_id = ?", claimString(claims, "user_id")).
+		Order("created_at DESC").
+		Find(&requests).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve leave requests"})
+	}
+	return c.JSON(http.StatusOK, requests)
+}
+
+// GetLeaveRequestQueue trả về hàng đợi duyệt đơn: giảng viên thấy đơn của các lớp mình phụ trách,
+// admin thấy tất cả. Lọc theo status (mặc định pending) và class_id.
+func GetLeaveRequestQueue(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+
+	status := c.QueryParam("status")
+	if status == "" {
+		status = models.LeaveStatusPending
+	}
+
+	query := config.DB.Preload("Schedules").Preload("Documents").
+		Where("leave_requests.status = ?", status)
+	if claimString(claims, "role") != "admin" {
+		query = query.Joins("JOIN classes c ON c.class_id = leave_requests.class_id").
+			Where("c.lecturer_id = ?", claimString(claims, "user_id"))
+	}
+	if classID := c.QueryParam("class_id"); classID != "" {
+		query = query.Where("leave_requests.class_id = ?", classID)
+	}
+
+	var requests []models.LeaveRequest
+	if err := query.Order("leave_requests.created_at").Find(&requests).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve leave requests"})
+	}
+
+	studentIDs := make([]uuid.UUID, 0, len(requests))
+	for _, r := range requests {
+		studentIDs = append(studentIDs, r.StudentID)
+	}
+	var students []struct {
+		StudentID   uuid.UUID
+		StudentCode string
+		FullName    string
+	}
+	if len(studentIDs) > 0 {
+		err := config.DB.Table("students s").
+			Select("s.student_id, s.student_code, u.first_name || ' ' || u.last_name AS full_name").
+			Joins("JOIN users u ON u.user_id = s.student_id").
+			Where("s.student_id IN ?", studentIDs).
+			Scan(&students).Error
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve students"})
+		}
+	}
+
+	views := make([]leaveRequestView, 0, len(requests))
+	for _, r := range requests {
+		view := leaveRequestView{LeaveRequest: r}
+		for _, s := range students {
+			if s.StudentID == r.StudentID {
+				view.StudentCode = s.StudentCode
+				view.FullName = s.FullName
+				break
+			}
+		}
+		views = append(views, view)
+	}
+	return c.JSON(http.StatusOK, views)
+}
+
+// ApproveLeaveRequest duyệt đơn và đánh dấu vắng có phép cho các buổi trong đơn.
+func ApproveLeaveRequest(c echo.Context) error {
+	return reviewLeaveRequest(c, models.LeaveStatusApproved)
+}
+
+// RejectLeaveRequest từ chối đơn xin nghỉ.
+func RejectLeaveRequest(c echo.Context) error {
+	return reviewLeaveRequest(c, models.LeaveStatusRejected)
+}
+
+func reviewLeaveRequest(c echo.Context, decision string) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	reviewerID, err := uuid.Parse(claimString(claims, "user_id"))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid token claims"})
+	}
+
+	var input struct {
+		Note *string `json:"note"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	var request models.LeaveRequest
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&request, "request_id = ?", c.Param("id")).Error; err != nil {
+			return err
+		}
+		allowed, err := canReviewClass(claims, request.ClassID)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return echo.NewHTTPError(http.StatusForbidden, "You do not teach this class")
+		}
+		if request.Status != models.LeaveStatusPending {
+			return echo.NewHTTPError(http.StatusConflict, "Leave request has already been reviewed")
+		}
+
+		now := time.Now()
+		request.Status = decision
+		request.ReviewedBy = &reviewerID
+		request.ReviewedAt = &now
+		request.ReviewNote = input.Note
+		if err := tx.Model(&request).Updates(map[string]interface{}{
+			"status":      request.Status,
+			"reviewed_by": request.ReviewedBy,
+			"reviewed_at": request.ReviewedAt,
+			"review_note": request.ReviewNote,
+		}).Error; err != nil {
+			return err
+		}
+
+		if decision != models.LeaveStatusApproved {
+			return nil
+		}
+		var schedules []models.Schedule
+		if err := tx.Joins("JOIN leave_request_schedules lrs ON lrs.schedule_id = schedules.schedule_id").
+			Where("lrs.request_id = ?", request.RequestID).
+			Find(&schedules).Error; err != nil {
+			return err
+		}
+		note := "Nghỉ có phép theo đơn " + request.RequestID.String()
+		for _, schedule := range schedules {
+			if _, err := recordExcused(tx, schedule, request.StudentID, note, attendanceActorFrom(c)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	var httpErr *echo.HTTPError
+	switch {
+	case errors.As(err, &httpErr):
+		return c.JSON(httpErr.Code, map[string]interface{}{"error": httpErr.Message})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Leave request not found"})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to review leave request"})
+	}
+
+	return c.JSON(http.StatusOK, request)
+}
+
+// CancelLeaveRequest cho phép sinh viên rút lại đơn đang chờ duyệt.
+func CancelLeaveRequest(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+
+	var request models.LeaveRequest
+	err := config.DB.Preload("Documents").
+		First(&request, "request_id = ? AND student_id = ?", c.Param("id"), claimString(claims, "user_id")).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Leave request not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to retrieve leave request"})
+	}
+
+	result := config.DB.Where("request_id = ? AND status = ?", request.RequestID, models.LeaveStatusPending).
+		Delete(&models.LeaveRequest{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to cancel leave request"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only pending leave requests can be cancelled"})
+	}
+	for _, d := range request.Documents {
+		if err := utils.RemoveUpload(d.StoragePath); err != nil {
+			log.Printf("Error removing leave document %s: %v", d.StoragePath, err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Leave request cancelled"})
+}
+
+// DownloadLeaveDocument trả về tài liệu đính kèm cho sinh viên gửi đơn hoặc người có quyền duyệt.
+func DownloadLeaveDocument(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+
+	var request models.LeaveRequest
+	if err := config.DB.First(&request, "request_id = ?", c.Param("id")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Leave request not found"})
+	}
+	if request.StudentID.String() != claimString(claims, "user_id") {
+		allowed, err := canReviewClass(claims, request.ClassID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to check permission"})
+		}
+		if !allowed {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden"})
+		}
+	}
+
+	var document models.LeaveRequestDocument
+	if err := config.DB.First(&document, "document_id = ? AND request_id = ?", c.Param("document_id"), request.RequestID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Document not found"})
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, document.ContentType)
+	return c.Attachment(utils.UploadPath(document.StoragePath), document.FileName)
+}
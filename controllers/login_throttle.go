@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginThrottleConfig là cấu hình chống dò mật khẩu, có thể ghi đè bằng biến môi trường
+type loginThrottleConfig struct {
+	MaxFailures     int
+	LockoutDuration time.Duration
+	FailureWindow   time.Duration
+	BackoffAfter    int
+	IPBackoffAfter  int
+	BackoffBase     time.Duration
+	BackoffMax      time.Duration
+}
+
+// Đọc khi sử dụng (không đọc lúc khởi tạo package) vì .env được nạp trong main
+func loadLoginThrottleConfig() loginThrottleConfig {
+	return loginThrottleConfig{
+		MaxFailures:     utils.GetEnvInt("LOGIN_MAX_FAILURES", 5),
+		LockoutDuration: utils.GetEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+		FailureWindow:   utils.GetEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		BackoffAfter:    utils.GetEnvInt("LOGIN_BACKOFF_AFTER", 2),
+		IPBackoffAfter:  utils.GetEnvInt("LOGIN_IP_BACKOFF_AFTER", 10),
+		BackoffBase:     utils.GetEnvDuration("LOGIN_BACKOFF_BASE", time.Second),
+		BackoffMax:      utils.GetEnvDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+	}
+}
+
+// dummyPasswordHash dùng để so sánh khi không tìm thấy user, giúp thời gian phản hồi như nhau
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+func accountThrottleKey(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginRetryAfter trả về thời gian phải chờ trước khi được thử đăng nhập lại (0 nếu được phép)
+// và cho biết tài khoản có đang bị khoá hay không.
+func loginRetryAfter(keys ...string) (time.Duration, bool, error) {
+	var throttles []models.LoginThrottle
+	if err := config.DB.Where("throttle_key IN ?", keys).Find(&throttles).Error; err != nil {
+		return 0, false, err
+	}
+
+	now := time.Now()
+	var wait time.Duration
+	locked := false
+	for _, t := range throttles {
+		if t.LockedUntil != nil && t.LockedUntil.After(now) {
+			locked = true
+			wait = max(wait, t.LockedUntil.Sub(now))
+		}
+		if t.BlockedUntil != nil && t.BlockedUntil.After(now) {
+			wait = max(wait, t.BlockedUntil.Sub(now))
+		}
+	}
+	return wait, locked, nil
+}
+
+// registerLoginFailure tăng bộ đếm thất bại của key và áp dụng backoff luỹ thừa.
+// Nếu lockAfter > 0 và số lần thất bại đạt ngưỡng, key bị khoá trong LockoutDuration.
+func registerLoginFailure(cfg loginThrottleConfig, key string, backoffAfter, lockAfter int) (bool, error) {
+	now := time.Now()
+
+	// Bộ đếm được đặt lại nếu lần thất bại trước đã nằm ngoài cửa sổ theo dõi
+	var count int
+	err := config.DB.Raw(`
+		INSERT INTO login_throttles (throttle_key, failed_count, last_failed_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (throttle_key) DO UPDATE SET
+			failed_count = CASE WHEN login_throttles.last_failed_at < ? THEN 1 ELSE login_throttles.failed_count + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+		RETURNING failed_count
+	`, key, now, now.Add(-cfg.FailureWindow)).Scan(&count).Error
+	if err != nil {
+		return false, err
+	}
+
+	updates := map[string]interface{}{}
+	if count > backoffAfter {
+		delay := cfg.BackoffBase << min(count-backoffAfter-1, 30)
+		if delay <= 0 || delay > cfg.BackoffMax {
+			delay = cfg.BackoffMax
+		}
+		updates["blocked_until"] = now.Add(delay)
+	}
+	locked := lockAfter > 0 && count >= lockAfter
+	if locked {
+		updates["locked_until"] = now.Add(cfg.LockoutDuration)
+	}
+	if len(updates) == 0 {
+		return false, nil
+	}
+
+	return locked, config.DB.Model(&models.LoginThrottle{}).Where("throttle_key = ?", key).Updates(updates).Error
+}
+
+// recordLoginFailure ghi nhận một lần đăng nhập thất bại theo IP và theo tài khoản (nếu có).
+func recordLoginFailure(user *models.User, ip string) {
+	cfg := loadLoginThrottleConfig()
+	if _, err := registerLoginFailure(cfg, ipThrottleKey(ip), cfg.IPBackoffAfter, 0); err != nil {
+		log.Printf("Error recording login failure for ip %s: %v", ip, err)
+	}
+	if user == nil {
+		return
+	}
+
+	locked, err := registerLoginFailure(cfg, accountThrottleKey(user.UserID), cfg.BackoffAfter, cfg.MaxFailures)
+	if err != nil {
+		log.Printf("Error recording login failure for user %s: %v", user.UserID, err)
+		return
+	}
+	if locked {
+		recordSecurityEvent(&user.UserID, nil, "account_locked", ip,
+			fmt.Sprintf("Locked for %s after %d failed login attempts", cfg.LockoutDuration, cfg.MaxFailures))
+	}
+}
+
+// resetLoginFailures xoá bộ đếm thất bại của tài khoản sau khi đăng nhập thành công.
+func resetLoginFailures(userID uuid.UUID) {
+	if err := config.DB.Where("throttle_key = ?", accountThrottleKey(userID)).Delete(&models.LoginThrottle{}).Error; err != nil {
+		log.Printf("Error resetting login failures for user %s: %v", userID, err)
+	}
+}
+
+// recordSecurityEvent lưu một sự kiện bảo mật; lỗi chỉ được ghi log để không chặn luồng chính.
+func recordSecurityEvent(userID, actorID *uuid.UUID, eventType, ip, detail string) {
+	event := models.SecurityEvent{
+		UserID:    userID,
+		ActorID:   actorID,
+		EventType: eventType,
+		IPAddress: ip,
+		Detail:    detail,
+	}
+	if err := config.DB.Create(&event).Error; err != nil {
+		log.Printf("Error recording security event %s: %v", eventType, err)
+	}
+}
+
+// tooManyAttempts trả về 429 kèm header Retry-After.
+func tooManyAttempts(c echo.Context, wait time.Duration, locked bool) error {
+	seconds := int(wait.Round(time.Second).Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	message := "Too many failed login attempts, please try again later"
+	if locked {
+		message = "Account temporarily locked due to too many failed login attempts"
+	}
+	return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+		"message":     message,
+		"retry_after": seconds,
+	})
+}
+
+// UnlockAccount cho phép admin mở khoá tài khoản bị khoá do đăng nhập sai nhiều lần.
+func UnlockAccount(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid user ID"})
+	}
+
+	var user models.User
+	if err := config.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "User not found"})
+	}
+
+	if err := config.DB.Where("throttle_key = ?", accountThrottleKey(userID)).Delete(&models.LoginThrottle{}).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to unlock account"})
+	}
+
+	var actorID *uuid.UUID
+	if claims, ok := c.Get("user").(jwt.MapClaims); ok {
+		if id, err := uuid.Parse(fmt.Sprint(claims["user_id"])); err == nil {
+			actorID = &id
+		}
+	}
+	recordSecurityEvent(&userID, actorID, "account_unlocked", c.RealIP(), "Unlocked by admin")
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Account unlocked successfully"})
+}
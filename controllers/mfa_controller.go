@@ -0,0 +1,385 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// claimsUser lấy user tương ứng với user_id trong JWT claims.
+func claimsUser(c echo.Context) (models.User, jwt.MapClaims, error) {
+	var user models.User
+	claims, ok := c.Get("user").(jwt.MapClaims)
+	if !ok {
+		return user, nil, errors.New("invalid token claims")
+	}
+	userID, _ := claims["user_id"].(string)
+	if err := config.DB.First(&user, "user_id = ?", userID).Error; err != nil {
+		return user, claims, err
+	}
+	return user, claims, nil
+}
+
+// mfaStatus cho biết user đã bật 2FA chưa và role của user có bắt buộc 2FA hay không.
+func mfaStatus(user models.User) (enabled bool, required bool, err error) {
+	var mfa models.UserMFA
+	err = config.DB.First(&mfa, "user_id = ?", user.UserID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, false, err
+	}
+	enabled = err == nil && mfa.Enabled
+
+	var policy models.MFAPolicy
+	err = config.DB.First(&policy, "role = ?", user.Role).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, false, err
+	}
+	return enabled, err == nil && policy.Required, nil
+}
+
+// issueLoginTokens cấp cặp access/refresh token cho một lần đăng nhập mới.
+func issueLoginTokens(c echo.Context, user models.User, extra map[string]interface{}) error {
+	// Mỗi lần đăng nhập mở một family refresh token mới
+	accessToken, refreshToken, _, err := issueTokenPair(config.DB, user, uuid.New())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate tokens"})
+	}
+
+	response := map[string]interface{}{
+		"message":       "Login successful",
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// verifyTOTP kiểm tra mã TOTP và cập nhật chu kỳ đã dùng để mã không bị dùng lại.
+func verifyTOTP(mfa models.UserMFA, code string) (bool, error) {
+	step, ok := utils.ValidateTOTP(mfa.Secret, code, time.Now())
+	if !ok || step <= mfa.LastUsedStep {
+		return false, nil
+	}
+
+	result := config.DB.Model(&models.UserMFA{}).
+		Where("user_id = ? AND last_used_step < ?", mfa.UserID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// useRecoveryCode đánh dấu một mã khôi phục là đã dùng nếu hợp lệ.
+func useRecoveryCode(userID uuid.UUID, code string) (bool, error) {
+	result := config.DB.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, utils.HashToken(utils.NormalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// replaceRecoveryCodes xoá các mã khôi phục cũ và sinh bộ mã mới, chỉ trả về bản rõ một lần.
+func replaceRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	records := make([]models.MFARecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, models.MFARecoveryCode{
+			UserID:   userID,
+			CodeHash: utils.HashToken(utils.NormalizeRecoveryCode(code)),
+		})
+	}
+	if err := tx.Create(&records).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// EnrollMFA tạo secret TOTP mới (chưa kích hoạt) và trả về URI otpauth để hiển thị mã QR.
+func EnrollMFA(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var existing models.UserMFA
+	if err := config.DB.First(&existing, "user_id = ?", user.UserID).Error; err == nil && existing.Enabled {
+		return c.JSON(http.StatusConflict, map[string]string{"message": "MFA is already enabled"})
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate MFA secret"})
+	}
+
+	mfa := models.UserMFA{UserID: user.UserID, Secret: secret}
+	if err := config.DB.Save(&mfa).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to save MFA secret"})
+	}
+
+	issuer := os.Getenv("MFA_ISSUER")
+	if issuer == "" {
+		issuer = "CMS"
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret":      secret,
+		"otpauth_uri": utils.TOTPURI(issuer, user.Email, secret),
+	})
+}
+
+// ConfirmMFAEnrollment kích hoạt 2FA sau khi người dùng nhập đúng mã TOTP đầu tiên.
+// Nếu gọi bằng token mfa_pending (role bắt buộc 2FA), phiên đăng nhập được hoàn tất luôn.
+func ConfirmMFAEnrollment(c echo.Context) error {
+	user, claims, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&input); err != nil || input.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	var mfa models.UserMFA
+	if err := config.DB.First(&mfa, "user_id = ?", user.UserID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "MFA enrollment not started"})
+	}
+	if mfa.Enabled {
+		return c.JSON(http.StatusConflict, map[string]string{"message": "MFA is already enabled"})
+	}
+
+	ok, err := verifyTOTP(mfa, input.Code)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to verify code"})
+	}
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid MFA code"})
+	}
+
+	var codes []string
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.UserMFA{}).Where("user_id = ?", user.UserID).
+			Updates(map[string]interface{}{"enabled": true, "enabled_at": now}).Error; err != nil {
+			return err
+		}
+		codes, err = replaceRecoveryCodes(tx, user.UserID)
+		return err
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to enable MFA"})
+	}
+	recordSecurityEvent(&user.UserID, &user.UserID, "mfa_enabled", c.RealIP(), "")
+
+	if typ, _ := claims["typ"].(string); typ == utils.TokenTypeMFAPending {
+		return issueLoginTokens(c, user, map[string]interface{}{"recovery_codes": codes})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":        "MFA enabled successfully",
+		"recovery_codes": codes,
+	})
+}
+
+// VerifyMFA hoàn tất đăng nhập bằng mã TOTP hoặc mã khôi phục, dùng token mfa_pending.
+func VerifyMFA(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+	if err := c.Bind(&input); err != nil || (input.Code == "" && input.RecoveryCode == "") {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	// Mã sai được tính chung vào giới hạn đăng nhập sai của tài khoản
+	ip := c.RealIP()
+	wait, locked, err := loginRetryAfter(ipThrottleKey(ip), accountThrottleKey(user.UserID))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check login attempts"})
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait, locked)
+	}
+
+	var mfa models.UserMFA
+	if err := config.DB.First(&mfa, "user_id = ? AND enabled = ?", user.UserID, true).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "MFA is not enabled"})
+	}
+
+	var ok bool
+	if input.Code != "" {
+		ok, err = verifyTOTP(mfa, input.Code)
+	} else {
+		ok, err = useRecoveryCode(user.UserID, input.RecoveryCode)
+		if ok {
+			recordSecurityEvent(&user.UserID, &user.UserID, "mfa_recovery_code_used", ip, "")
+		}
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to verify code"})
+	}
+	if !ok {
+		recordLoginFailure(&user, ip)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid MFA code"})
+	}
+
+	resetLoginFailures(user.UserID)
+	return issueLoginTokens(c, user, nil)
+}
+
+// confirmTOTP kiểm tra mã TOTP hiện tại trước một thao tác nhạy cảm (action) với cùng giới hạn
+// thử sai như VerifyMFA. Trả về false khi đã ghi response lỗi; err là kết quả ghi response đó.
+func confirmTOTP(c echo.Context, user models.User, code, action string) (bool, error) {
+	ip := c.RealIP()
+	wait, locked, err := loginRetryAfter(ipThrottleKey(ip), accountThrottleKey(user.UserID))
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check login attempts"})
+	}
+	if wait > 0 {
+		return false, tooManyAttempts(c, wait, locked)
+	}
+
+	var mfa models.UserMFA
+	if err := config.DB.First(&mfa, "user_id = ? AND enabled = ?", user.UserID, true).Error; err != nil {
+		return false, c.JSON(http.StatusBadRequest, map[string]string{"message": "MFA is not enabled"})
+	}
+	ok, err := verifyTOTP(mfa, code)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to verify code"})
+	}
+	if !ok {
+		recordLoginFailure(&user, ip)
+		recordSecurityEvent(&user.UserID, &user.UserID, "mfa_code_failed", ip, action)
+		return false, c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid MFA code"})
+	}
+
+	resetLoginFailures(user.UserID)
+	return true, nil
+}
+
+// RegenerateRecoveryCodes sinh lại bộ mã khôi phục, yêu cầu mã TOTP hiện tại.
+func RegenerateRecoveryCodes(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&input); err != nil || input.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	if ok, err := confirmTOTP(c, user, input.Code, "regenerate_recovery_codes"); !ok {
+		return err
+	}
+
+	codes, err := replaceRecoveryCodes(config.DB, user.UserID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to generate recovery codes"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{"recovery_codes": codes})
+}
+
+// DisableMFA tắt 2FA (yêu cầu mã TOTP hiện tại), không cho phép nếu role bắt buộc 2FA.
+func DisableMFA(c echo.Context) error {
+	user, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.Bind(&input); err != nil || input.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	_, required, err := mfaStatus(user)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to check MFA policy"})
+	}
+	if required {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "MFA is required for your role"})
+	}
+
+	if ok, err := confirmTOTP(c, user, input.Code, "disable_mfa"); !ok {
+		return err
+	}
+
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.UserID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.UserID).Delete(&models.UserMFA{}).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to disable MFA"})
+	}
+	recordSecurityEvent(&user.UserID, &user.UserID, "mfa_disabled", c.RealIP(), "")
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "MFA disabled successfully"})
+}
+
+// GetMFAPolicies trả về danh sách role đang bắt buộc/không bắt buộc 2FA.
+func GetMFAPolicies(c echo.Context) error {
+	var policies []models.MFAPolicy
+	if err := config.DB.Order("role").Find(&policies).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to retrieve MFA policies"})
+	}
+	return c.JSON(http.StatusOK, policies)
+}
+
+// UpdateMFAPolicy bật/tắt yêu cầu 2FA cho một role.
+func UpdateMFAPolicy(c echo.Context) error {
+	role := c.Param("role")
+	if role != "admin" && role != "lecturer" && role != "student" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid role"})
+	}
+
+	var input struct {
+		Required bool `json:"required"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid request"})
+	}
+
+	admin, _, err := claimsUser(c)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "User not found"})
+	}
+
+	policy := models.MFAPolicy{Role: role, Required: input.Required, UpdatedBy: &admin.UserID}
+	if err := config.DB.Save(&policy).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to update MFA policy"})
+	}
+	recordSecurityEvent(nil, &admin.UserID, "mfa_policy_updated", c.RealIP(), role)
+
+	return c.JSON(http.StatusOK, policy)
+}
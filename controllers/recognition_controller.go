@@ -0,0 +1,176 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const defaultRecognitionMinConfidence = 0.6
+
+// recognitionMinConfidence đọc ngưỡng độ tin cậy tối thiểu từ RECOGNITION_MIN_CONFIDENCE.
+func recognitionMinConfidence() float64 {
+	if v, err := strconv.ParseFloat(os.Getenv("RECOGNITION_MIN_CONFIDENCE"), 64); err == nil && v >= 0 {
+		return v
+	}
+	return defaultRecognitionMinConfidence
+}
+
+// activeScheduleForCamera tìm buổi học đang diễn ra trong phòng gắn camera nhận dạng tại thời điểm at.
+// Sinh viên đến sớm (trong ATTENDANCE_EARLY_WINDOW trước giờ bắt đầu) vẫn được tính cho buổi đó.
+func activeScheduleForCamera(cameraID uuid.UUID, at time.Time) (models.Schedule, error) {
+	earlyWindow := utils.GetEnvDuration("ATTENDANCE_EARLY_WINDOW", 15*time.Minute)
+
+	var schedule models.Schedule
+	err := config.DB.Table("schedules s").
+		Select("s.*").
+		Joins("JOIN cameras cam ON cam.classroom_id = s.classroom_id").
+		Where("cam.camera_id = ? AND cam.camera_type = 'recognition'", cameraID).
+		Where("s.start_time <= ? AND s.end_time >= ?", at.Add(earlyWindow), at).
+		Order("s.start_time").
+		Limit(1).
+		Scan(&schedule).Error
+	return schedule, err
+}
+
+// isEnrolled kiểm tra sinh viên có thuộc lớp của buổi học hay không.
+func isEnrolled(classID, studentID uuid.UUID) (bool, error) {
+	var count int64
+	err := config.DB.Model(&models.ClassStudent{}).
+		Where("class_id = ? AND student_id = ?", classID, studentID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// IngestRecognitionEvent nhận sự kiện nhận dạng từ camera, xác định buổi học đang diễn ra
+// trong phòng của camera và tạo/cập nhật bản ghi điểm danh tương ứng.
+func IngestRecognitionEvent(c echo.Context) error {
+	var input struct {
+		CameraID         uuid.UUID  `json:"camera_id"`
+		StudentID        *uuid.UUID `json:"student_id"` // Camera đã tự nhận dạng
+		Embedding        []float32  `json:"embedding"`  // Hoặc gửi embedding để backend nhận dạng
+		Timestamp        *time.Time `json:"timestamp"`
+		Confidence       float64    `json:"confidence"`
+		EvidenceImageURL *string    `json:"evidence_image_url"`
+	}
+	if err := c.Bind(&input); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request body"})
+	}
+	if input.CameraID == uuid.Nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "camera_id is required"})
+	}
+	if input.StudentID == nil && len(input.Embedding) == 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "student_id or embedding is required"})
+	}
+
+	capturedAt := time.Now()
+	if input.Timestamp != nil {
+		capturedAt = *input.Timestamp
+	}
+	if capturedAt.After(time.Now().Add(5 * time.Minute)) {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "timestamp is in the future"})
+	}
+
+	event := models.RecognitionEvent{
+		CameraID:         input.CameraID,
+		StudentID:        input.StudentID,
+		CapturedAt:       capturedAt,
+		Confidence:       input.Confidence,
+		EvidenceImageURL: input.EvidenceImageURL,
+	}
+
+	schedule, err := activeScheduleForCamera(input.CameraID, capturedAt)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to resolve schedule"})
+	}
+	if schedule.ScheduleID == uuid.Nil {
+		event.Result = "no_schedule"
+		return saveRecognitionEvent(c, event)
+	}
+	event.ScheduleID = &schedule.ScheduleID
+
+	// Xác định danh tính từ embedding, chỉ so khớp với sinh viên của lớp
+	if event.StudentID == nil {
+		model, httpErr := resolveFaceModel("", "")
+		if httpErr != nil {
+			return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+		}
+		if len(input.Embedding) != model.Dimension {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": "embedding must have " + strconv.Itoa(model.Dimension) + " dimensions"})
+		}
+
+		matches, err := identifyFaces(input.Embedding, model, faceCandidateFilter{ScheduleID: schedule.ScheduleID.String()}, 1, faceMatchThreshold())
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to identify face"})
+		}
+		if len(matches) > 0 {
+			logBiometricAccess(c, "face_embedding", "identify", []biometricAccess{{SubjectUserID: matches[0].UserID}})
+			event.Distance = &matches[0].Distance
+			if matches[0].Accepted {
+				event.StudentID = &matches[0].UserID
+			}
+		}
+		if event.StudentID == nil {
+			event.Result = "unresolved"
+			return saveRecognitionEvent(c, event)
+		}
+	} else {
+		enrolled, err := isEnrolled(schedule.ClassID, *event.StudentID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check enrollment"})
+		}
+		if !enrolled {
+			event.Result = "unresolved"
+			return saveRecognitionEvent(c, event)
+		}
+	}
+
+	if input.Confidence < recognitionMinConfidence() {
+		event.Result = "low_confidence"
+		return saveRecognitionEvent(c, event)
+	}
+
+	event.Result = "applied"
+	var attendance models.Attendance
+	err = config.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		attendance, _, err = recordArrival(tx, schedule, *event.StudentID, capturedAt, input.EvidenceImageURL, models.AttendanceChannelCamera, attendanceActorFrom(c))
+		if err != nil {
+			return err
+		}
+		event.AttendanceID = &attendance.AttendanceID
+		return tx.Create(&event).Error
+	})
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to record attendance"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"event":      event,
+		"attendance": attendance,
+	})
+}
+
+// saveRecognitionEvent lưu sự kiện không được áp dụng vào điểm danh và trả về 202.
+func saveRecognitionEvent(c echo.Context, event models.RecognitionEvent) error {
+	if err := config.DB.Create(&event).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to save recognition event"})
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{
+		"event":      event,
+		"attendance": nil,
+	})
+}
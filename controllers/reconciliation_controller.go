@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Kết quả đối soát số người đếm được với số sinh viên được điểm danh có mặt
+const (
+	reconciliationOK                  = "ok"
+	reconciliationNoHeadcount         = "no_headcount"       // Chưa có snapshot từ camera giám sát
+	reconciliationHeadcountExceeds    = "headcount_exceeds"  // Nhiều người hơn số có mặt: nhận diện sót hoặc người ngoài lớp
+	reconciliationAttendanceExceeds   = "attendance_exceeds" // Số có mặt vượt số người đếm được: nghi điểm danh hộ
+	reconciliationDefaultTolerance    = 2                    // Bù cho giảng viên, trợ giảng và sai số bộ đếm
+	reconciliationDefaultTolerancePct = 10                   // Theo phần trăm sĩ số
+)
+
+// ScheduleReconciliation so sánh bộ đếm người của camera giám sát với điểm danh của một buổi học.
+type ScheduleReconciliation struct {
+	ScheduleID       uuid.UUID `json:"schedule_id"`
+	ClassID          uuid.UUID `json:"class_id"`
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+	SnapshotCount    int       `json:"snapshot_count"`
+	PeakHeadcount    *int      `json:"peak_headcount"`
+	MedianHeadcount  *float64  `json:"median_headcount"`
+	AttendedCount    int       `json:"attended_count"`   // Có mặt + đi muộn, mọi kênh điểm danh
+	RecognizedCount  int       `json:"recognized_count"` // Có mặt + đi muộn do camera nhận diện
+	EnrolledCount    int       `json:"enrolled_count"`
+	Tolerance        int       `json:"tolerance" gorm:"-"`
+	Flag             string    `json:"flag" gorm:"-"`
+	HeadcountSurplus *float64  `json:"headcount_surplus" gorm:"-"` // Trung vị số người đếm được - số có mặt
+}
+
+// reconciliationTolerance là độ lệch cho phép trước khi gắn cờ: lấy giá trị lớn hơn giữa
+// RECONCILIATION_TOLERANCE (người) và RECONCILIATION_TOLERANCE_PERCENT (% sĩ số).
+func reconciliationTolerance(enrolled int) int {
+	tolerance := utils.GetEnvInt("RECONCILIATION_TOLERANCE", reconciliationDefaultTolerance)
+	pct := utils.GetEnvInt("RECONCILIATION_TOLERANCE_PERCENT", reconciliationDefaultTolerancePct)
+	if byPct := int(math.Ceil(float64(enrolled) * float64(pct) / 100)); byPct > tolerance {
+		tolerance = byPct
+	}
+	return tolerance
+}
+
+// classify gắn cờ cho buổi học. Trung vị được dùng để so với số có mặt vì ít bị ảnh hưởng bởi
+// người ra vào chốc lát; đỉnh được dùng để phát hiện điểm danh hộ vì số có mặt không thể vượt
+// số người đông nhất từng đếm được trong phòng.
+func (r *ScheduleReconciliation) classify() {
+	r.Tolerance = reconciliationTolerance(r.EnrolledCount)
+	if r.SnapshotCount == 0 || r.PeakHeadcount == nil || r.MedianHeadcount == nil {
+		r.Flag = reconciliationNoHeadcount
+		return
+	}
+
+	surplus := *r.MedianHeadcount - float64(r.AttendedCount)
+	r.HeadcountSurplus = &surplus
+	switch {
+	case float64(r.AttendedCount-*r.PeakHeadcount) > float64(r.Tolerance):
+		r.Flag = reconciliationAttendanceExceeds
+	case surplus > float64(r.Tolerance):
+		r.Flag = reconciliationHeadcountExceeds
+	default:
+		r.Flag = reconciliationOK
+	}
+}
+
+// loadReconciliations tính số liệu đối soát cho các buổi học thoả điều kiện where, trong một câu truy vấn.
+func loadReconciliations(where string, args ...interface{}) ([]ScheduleReconciliation, error) {
+	attended := []string{models.AttendanceStatusPresent, models.AttendanceStatusLate}
+	query := `
+		SELECT s.schedule_id, s.class_id, s.start_time, s.end_time,
+			hc.snapshot_count, hc.peak_headcount, hc.median_headcount,
+			att.attended_count, att.recognized_count,
+			(
+				SELECT COUNT(*) FROM class_students cs
+				WHERE cs.class_id = s.class_id AND COALESCE(cs.status, '') NOT IN ?
+			) AS enrolled_count
+		FROM schedules s
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS snapshot_count,
+				MAX(p.people_counter) AS peak_headcount,
+				percentile_cont(0.5) WITHIN GROUP (ORDER BY p.people_counter) AS median_headcount
+			FROM people_count_snapshots p
+			WHERE p.schedule_id = s.schedule_id
+		) hc ON true
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) FILTER (WHERE a.status IN ?) AS attended_count,
+				-- Bản ghi cũ chưa có channel: có ảnh minh chứng nghĩa là do camera ghi nhận
+				COUNT(*) FILTER (WHERE a.status IN ? AND (a.channel = ? OR (a.channel IS NULL AND a.evidence_image_url IS NOT NULL))) AS recognized_count
+			FROM attendance a
+			WHERE a.schedule_id = s.schedule_id
+		) att ON true
+		WHERE ` + where + `
+		ORDER BY s.start_time`
+
+	params := append([]interface{}{inactiveClassStudentStatuses, attended, attended, models.AttendanceChannelCamera}, args...)
+	var results []ScheduleReconciliation
+	if err := config.DB.Raw(query, params...).Scan(&results).Error; err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].classify()
+	}
+	return results, nil
+}
+
+// GetScheduleReconciliation đối soát số người đếm được với điểm danh của một buổi học.
+func GetScheduleReconciliation(c echo.Context) error {
+	schedule, httpErr := loadManagedSchedule(c)
+	if httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
+	results, err := loadReconciliations("s.schedule_id = ?", schedule.ScheduleID)
+	if err != nil || len(results) == 0 {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reconcile attendance"})
+	}
+	return c.JSON(http.StatusOK, results[0])
+}
+
+// GetClassReconciliation đối soát tất cả các buổi học đã bắt đầu của một lớp.
+// Truyền flagged=true để chỉ lấy các buổi bị gắn cờ lệch.
+func GetClassReconciliation(c echo.Context) error {
+	classID, err := uuid.Parse(c.Param("class_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid class_id"})
+	}
+	var class models.Class
+	err = config.DB.First(&class, "class_id = ?", classID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"error": "Class not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve class"})
+	}
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	allowed, err := canReviewClass(claims, classID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to check permission"})
+	}
+	if !allowed {
+		return c.JSON(http.StatusForbidden, echo.Map{"error": "You do not teach this class"})
+	}
+
+	results, err := loadReconciliations("s.class_id = ? AND s.start_time <= ?", classID, time.Now())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to reconcile attendance"})
+	}
+	if c.QueryParam("flagged") == "true" {
+		flagged := make([]ScheduleReconciliation, 0, len(results))
+		for _, r := range results {
+			if r.Flag == reconciliationHeadcountExceeds || r.Flag == reconciliationAttendanceExceeds {
+				flagged = append(flagged, r)
+			}
+		}
+		results = flagged
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Độ chi tiết của báo cáo điểm danh
+const (
+	ReportGranularityDay   = "day"
+	ReportGranularityWeek  = "week" // Tuần ISO 8601, bắt đầu từ thứ Hai
+	ReportGranularityMonth = "month"
+	ReportGranularityTerm  = "term" // Học kỳ, mốc bắt đầu đọc từ REPORT_TERM_START_MONTHS
+
+	reportDateLayout = "2006-01-02"
+	maxReportBuckets = 1000
+)
+
+// reportBucket là một khoảng [Start, End) theo múi giờ của báo cáo.
+type reportBucket struct {
+	Label string
+	Start time.Time
+	End   time.Time
+}
+
+// ReportRow là số liệu điểm danh của một khoảng thời gian trong báo cáo.
+type ReportRow struct {
+	Period  string `json:"period"`     // Nhãn: 2025-09-01, 2025-W36, 2025-09, HK1 2025-2026
+	Start   string `json:"start_date"` // Ngày đầu khoảng (theo múi giờ báo cáo)
+	End     string `json:"end_date"`   // Ngày cuối khoảng, tính cả ngày này
+	Present int    `json:"present"`
+	Late    int    `json:"late"`
+	Absent  int    `json:"absent"`
+	Excused int    `json:"excused"`
+}
+
+// reportLocation trả về múi giờ IANA của báo cáo: tham số tz, sau đó REPORT_TIMEZONE, cuối cùng là múi giờ máy chủ.
+func reportLocation(name string) (*time.Location, error) {
+	if name == "" {
+		name = os.Getenv("REPORT_TIMEZONE")
+	}
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// reportTermStartMonths đọc các tháng bắt đầu học kỳ từ REPORT_TERM_START_MONTHS (mặc định "9,2,7":
+// HK1 từ tháng 9, HK2 từ tháng 2, HK3 từ tháng 7). Tháng đầu tiên là đầu năm học.
+func reportTermStartMonths() ([]time.Month, error) {
+	value := os.Getenv("REPORT_TERM_START_MONTHS")
+	if value == "" {
+		value = "9,2,7"
+	}
+	var months []time.Month
+	seen := map[int]bool{}
+	for _, raw := range strings.Split(value, ",") {
+		m, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || m < 1 || m > 12 || seen[m] {
+			return nil, fmt.Errorf("invalid REPORT_TERM_START_MONTHS %q", value)
+		}
+		seen[m] = true
+		months = append(months, time.Month(m))
+	}
+	return months, nil
+}
+
+// termOf trả về mốc bắt đầu và nhãn học kỳ chứa thời điểm t.
+func termOf(t time.Time, starts []time.Month) (time.Time, string) {
+	sorted := append([]time.Month(nil), starts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// Mốc học kỳ gần nhất không sau t: tìm trong năm của t, nếu không có thì lấy mốc cuối của năm trước
+	year, month := t.Year(), sorted[len(sorted)-1]
+	found := false
+	for i := len(sorted) - 1; i >= 0; i-- {
+		if sorted[i] <= t.Month() {
+			month, found = sorted[i], true
+			break
+		}
+	}
+	if !found {
+		year--
+	}
+	start := time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+
+	index := 0
+	for i, m := range starts {
+		if m == month {
+			index = i
+		}
+	}
+	academicYear := year
+	if month < starts[0] {
+		academicYear--
+	}
+	return start, fmt.Sprintf("HK%d %d-%d", index+1, academicYear, academicYear+1)
+}
+
+// nextBucketStart trả về mốc bắt đầu của khoảng kế tiếp sau khoảng chứa t, kèm nhãn của khoảng chứa t.
+func nextBucketStart(t time.Time, granularity string, termStarts []time.Month) (time.Time, string) {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch granularity {
+	case ReportGranularityWeek:
+		year, week := day.ISOWeek()
+		offset := (int(day.Weekday()) + 6) % 7 // Số ngày kể từ thứ Hai
+		return day.AddDate(0, 0, 7-offset), fmt.Sprintf("%d-W%02d", year, week)
+	case ReportGranularityMonth:
+		first := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return first.AddDate(0, 1, 0), first.Format("2006-01")
+	case ReportGranularityTerm:
+		start, label := termOf(day, termStarts)
+		// Học kỳ kéo dài tới mốc bắt đầu học kỳ tiếp theo
+		next := start.AddDate(0, 1, 0)
+		for {
+			nextStart, _ := termOf(next, termStarts)
+			if !nextStart.Equal(start) {
+				return nextStart, label
+			}
+			next = next.AddDate(0, 1, 0)
+		}
+	default:
+		return day.AddDate(0, 0, 1), day.Format(reportDateLayout)
+	}
+}
+
+// buildReportBuckets chia [from, to) thành các khoảng liên tiếp theo lịch của múi giờ from.
+// Khoảng đầu và cuối được cắt theo phạm vi báo cáo.
+func buildReportBuckets(from, to time.Time, granularity string) ([]reportBucket, error) {
+	var termStarts []time.Month
+	switch granularity {
+	case ReportGranularityDay, ReportGranularityWeek, ReportGranularityMonth:
+	case ReportGranularityTerm:
+		var err error
+		if termStarts, err = reportTermStartMonths(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("granularity must be day, week, month or term")
+	}
+	if !from.Before(to) {
+		return nil, errors.New("from must not be after to")
+	}
+
+	var buckets []reportBucket
+	for start := from; start.Before(to); {
+		end, label := nextBucketStart(start, granularity, termStarts)
+		if end.After(to) {
+			end = to
+		}
+		buckets = append(buckets, reportBucket{Label: label, Start: start, End: end})
+		if len(buckets) > maxReportBuckets {
+			return nil, fmt.Errorf("the report would have more than %d periods", maxReportBuckets)
+		}
+		start = end
+	}
+	return buckets, nil
+}
+
+// timestampArray tạo literal mảng timestamptz của Postgres từ danh sách thời điểm.
+func timestampArray(times []time.Time) string {
+	values := make([]string, len(times))
+	for i, t := range times {
+		values[i] = `"` + t.Format(time.RFC3339) + `"`
+	}
+	return "{" + strings.Join(values, ",") + "}"
+}
+
+// runAttendanceReport đếm điểm danh theo từng khoảng trong một câu truy vấn gom nhóm.
+// Các khoảng không có dữ liệu vẫn được trả về với số liệu 0.
+func runAttendanceReport(buckets []reportBucket, where string, args ...interface{}) ([]ReportRow, error) {
+	starts := make([]time.Time, len(buckets))
+	ends := make([]time.Time, len(buckets))
+	for i, b := range buckets {
+		starts[i], ends[i] = b.Start, b.End
+	}
+
+	query := `
+		WITH buckets AS (
+			SELECT b.idx, b.bucket_start, b.bucket_end
+			FROM unnest(?::timestamptz[], ?::timestamptz[]) WITH ORDINALITY AS b(bucket_start, bucket_end, idx)
+		),
+		data AS (
+			SELECT s.start_time, a.status
+			FROM attendance a
+			JOIN schedules s ON a.schedule_id = s.schedule_id
+			JOIN classes c ON s.class_id = c.class_id
+			WHERE s.start_time >= ? AND s.start_time < ? AND ` + where + `
+		)
+		SELECT b.idx,
+			` + countStatusSQL("d", models.AttendanceStatusPresent) + ` AS present,
+			` + countStatusSQL("d", models.AttendanceStatusLate) + ` AS late,
+			` + countStatusSQL("d", models.AttendanceStatusAbsent) + ` AS absent,
+			` + countStatusSQL("d", models.AttendanceStatusExcused) + ` AS excused
+		FROM buckets b
+		LEFT JOIN data d ON d.start_time >= b.bucket_start AND d.start_time < b.bucket_end
+		GROUP BY b.idx
+		ORDER BY b.idx`
+	params := append([]interface{}{
+		timestampArray(starts), timestampArray(ends), buckets[0].Start, buckets[len(buckets)-1].End,
+	}, args...)
+
+	var counts []struct {
+		Idx     int
+		Present int
+		Late    int
+		Absent  int
+		Excused int
+	}
+	if err := config.DB.Raw(query, params...).Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	rows := make([]ReportRow, len(buckets))
+	for i, b := range buckets {
+		rows[i] = ReportRow{
+			Period: b.Label,
+			Start:  b.Start.Format(reportDateLayout),
+			End:    b.End.Add(-time.Nanosecond).Format(reportDateLayout),
+		}
+	}
+	for _, count := range counts {
+		if count.Idx < 1 || count.Idx > len(rows) {
+			continue
+		}
+		row := &rows[count.Idx-1]
+		row.Present, row.Late, row.Absent, row.Excused = count.Present, count.Late, count.Absent, count.Excused
+	}
+	return rows, nil
+}
@@ -87,6 +87,11 @@ func AddSubject(c echo.Context) error {
 
 	}
 
+	// Giảng viên chỉ được xếp lịch cho lớp mình phụ trách
+	if httpErr := requireClassReviewer(c, input.ClassID); httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
 	// Validate thời gian (optional)
 	if input.StartTime.IsZero() || input.EndTime.IsZero() || input.EndTime.Before(input.StartTime) {
 		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid start/end time"})
@@ -118,6 +123,13 @@ func UpdateSubject(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, echo.Map{"error": "Schedule not found"})
 	}
 
+	// Giảng viên phải phụ trách cả lớp hiện tại và lớp mới của buổi học
+	for _, classID := range []uuid.UUID{existing.ClassID, input.ClassID} {
+		if httpErr := requireClassReviewer(c, classID); httpErr != nil {
+			return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+		}
+	}
+
 	// Cập nhật các trường
 	existing.ClassID = input.ClassID
 	existing.ClassroomID = input.ClassroomID
@@ -154,6 +166,10 @@ func DeleteSchedule(c echo.Context) error {
 		})
 	}
 
+	if httpErr := requireClassReviewer(c, schedule.ClassID); httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"error": httpErr.Message})
+	}
+
 	if err := config.DB.Delete(&schedule).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": "Failed to delete schedule",
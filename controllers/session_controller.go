@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/models"
+	"cms-backend/utils"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+var (
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+	errRefreshTokenExpired = errors.New("refresh token expired")
+)
+
+// issueTokenPair tạo access token và refresh token mới thuộc familyID,
+// đồng thời lưu hash của refresh token vào bảng refresh_tokens.
+func issueTokenPair(tx *gorm.DB, user models.User, familyID uuid.UUID) (string, string, uuid.UUID, error) {
+	accessToken, err := utils.GenerateAccessToken(user)
+	if err != nil {
+		return "", "", uuid.Nil, err
+	}
+
+	tokenID := uuid.New()
+	refreshToken, err := utils.GenerateRefreshToken(user, tokenID, familyID)
+	if err != nil {
+		return "", "", uuid.Nil, err
+	}
+
+	record := models.RefreshToken{
+		TokenID:   tokenID,
+		UserID:    user.UserID,
+		FamilyID:  familyID,
+		TokenHash: utils.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", "", uuid.Nil, err
+	}
+
+	return accessToken, refreshToken, tokenID, nil
+}
+
+// rotateRefreshToken thu hồi refresh token hiện tại và cấp cặp token mới trong cùng family.
+// Nếu token đã bị thu hồi trước đó (bị dùng lại), toàn bộ family sẽ bị thu hồi.
+func rotateRefreshToken(refreshToken string) (string, string, error) {
+	var accessToken, newRefreshToken string
+
+	err := config.DB.Transaction(func(tx *gorm.DB) error {
+		var stored models.RefreshToken
+		if err := tx.First(&stored, "token_hash = ?", utils.HashToken(refreshToken)).Error; err != nil {
+			return err
+		}
+
+		if stored.RevokedAt != nil {
+			return errRefreshTokenReused
+		}
+		if time.Now().After(stored.ExpiresAt) {
+			return errRefreshTokenExpired
+		}
+
+		var user models.User
+		if err := tx.First(&user, "user_id = ?", stored.UserID).Error; err != nil {
+			return err
+		}
+
+		var newTokenID uuid.UUID
+		var err error
+		accessToken, newRefreshToken, newTokenID, err = issueTokenPair(tx, user, stored.FamilyID)
+		if err != nil {
+			return err
+		}
+
+		// Chỉ một request được phép xoay vòng token này, request đồng thời còn lại bị coi là dùng lại
+		result := tx.Model(&models.RefreshToken{}).
+			Where("token_id = ? AND revoked_at IS NULL", stored.TokenID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "replaced_by": newTokenID})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+		return nil
+	})
+
+	if errors.Is(err, errRefreshTokenReused) {
+		// Thu hồi cả family bên ngoài transaction để không bị rollback
+		revokeRefreshFamily(refreshToken)
+	}
+	return accessToken, newRefreshToken, err
+}
+
+// revokeRefreshFamily thu hồi tất cả refresh token cùng family với token được truyền vào.
+func revokeRefreshFamily(refreshToken string) error {
+	var stored models.RefreshToken
+	if err := config.DB.First(&stored, "token_hash = ?", utils.HashToken(refreshToken)).Error; err != nil {
+		return err
+	}
+	return config.DB.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", stored.FamilyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// revokeUserRefreshTokens thu hồi mọi refresh token còn hiệu lực của một user.
+func revokeUserRefreshTokens(userID uuid.UUID) error {
+	return config.DB.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// bearerToken lấy token từ header Authorization dạng "Bearer <token>".
+func bearerToken(c echo.Context) (string, bool) {
+	authHeader := c.Request().Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	return token, token != ""
+}
+
+// Logout thu hồi phiên đăng nhập hiện tại (toàn bộ family của refresh token).
+func Logout(c echo.Context) error {
+	refreshToken, ok := bearerToken(c)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Missing refresh token"})
+	}
+
+	if _, err := utils.ParseToken(refreshToken, utils.TokenTypeRefresh); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+	}
+
+	if err := revokeRefreshFamily(refreshToken); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid or expired refresh token"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to logout"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out successfully"})
+}
+
+// LogoutAll thu hồi mọi phiên đăng nhập của user hiện tại trên tất cả thiết bị.
+func LogoutAll(c echo.Context) error {
+	claims, ok := c.Get("user").(jwt.MapClaims)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid token claims"})
+	}
+
+	userIDStr, _ := claims["user_id"].(string)
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid user ID in token"})
+	}
+
+	if err := revokeUserRefreshTokens(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to logout"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Logged out from all sessions"})
+}
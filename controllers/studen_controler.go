@@ -28,6 +28,20 @@ func UpdateStudent(c echo.Context) error {
 		})
 	}
 
+	// Giảng viên chỉ được sửa sinh viên thuộc lớp mình phụ trách
+	if httpErr := requireClassReviewer(c, req.ClassID); httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+	}
+	var enrolled int64
+	if err := config.DB.Model(&models.ClassStudent{}).
+		Where("student_id = ? AND class_id = ?", studentID, req.ClassID).
+		Count(&enrolled).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": "Failed to check class student"})
+	}
+	if enrolled == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "Student is not in this class"})
+	}
+
 	// Cập nhật thông tin trong bảng `users`
 	if err := config.DB.Model(&models.User{}).
 		Where("user_id = ?", studentID).
@@ -68,7 +82,13 @@ func UpdateStudent(c echo.Context) error {
 
 func DeleteStudentFromClass(c echo.Context) error {
 	studentID := c.Param("student_id")
-	classID := c.Param("class_id")
+	classID, err := uuid.Parse(c.Param("class_id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "Invalid class_id"})
+	}
+	if httpErr := requireClassReviewer(c, classID); httpErr != nil {
+		return c.JSON(httpErr.Code, echo.Map{"message": httpErr.Message})
+	}
 
 	// Xóa sinh viên khỏi lớp trong bảng `class_students`
 	if err := config.DB.Where("student_id = ? AND class_id = ?", studentID, classID).Delete(&models.ClassStudent{}).Error; err != nil {
@@ -167,5 +187,16 @@ func GetStudentAttendances(c echo.Context) error {
 		}
 	}
 
+	// Ghi log truy cập ảnh minh chứng
+	if studentUUID, err := uuid.Parse(studentID); err == nil {
+		var accesses []biometricAccess
+		for _, r := range results {
+			if r.EvidenceImageURL != "" {
+				accesses = append(accesses, biometricAccess{SubjectUserID: studentUUID, ResourceID: r.AttendanceID})
+			}
+		}
+		logBiometricAccess(c, "evidence_image", "read", accesses)
+	}
+
 	return c.JSON(http.StatusOK, results)
 }
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"cms-backend/config"
+	"cms-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// TranscriptSession là một buổi học đã diễn ra của lớp và điểm danh của sinh viên ở buổi đó.
+// Các trường điểm danh là null khi buổi học chưa có bản ghi.
+type TranscriptSession struct {
+	ScheduleID       uuid.UUID  `json:"schedule_id"`
+	ClassID          uuid.UUID  `json:"class_id"`
+	StartTime        time.Time  `json:"start_time"`
+	EndTime          time.Time  `json:"end_time"`
+	Topic            string     `json:"topic"`
+	AttendanceID     *uuid.UUID `json:"attendance_id"`
+	Status           *string    `json:"status"`
+	AttendanceTime   *time.Time `json:"attendance_time"`
+	Channel          *string    `json:"channel"`
+	Note             *string    `json:"note"`
+	EvidenceImageURL *string    `json:"evidence_image_url"`
+}
+
+// TranscriptCourse là kết quả điểm danh của sinh viên trong một lớp: số liệu và kết quả xét dự thi,
+// tỉ lệ đi học trên số buổi đã diễn ra và danh sách các buổi.
+type TranscriptCourse struct {
+	ExamEligibility
+	AttendanceRate float64             `json:"attendance_rate"` // (có mặt + đi muộn) / số buổi đã diễn ra, %
+	Sessions       []TranscriptSession `json:"sessions"`
+}
+
+// loadStudentTranscript tổng hợp điểm danh của sinh viên ở mọi lớp đang theo học.
+func loadStudentTranscript(studentID string) ([]TranscriptCourse, error) {
+	eligibility, err := loadExamEligibility(config.DB, "cs.student_id = ?", studentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []TranscriptSession
+	err = config.DB.Raw(`
+		SELECT s.schedule_id, s.class_id, s.start_time, s.end_time, s.topic,
+			a.attendance_id, a.status, a.attendance_time, a.channel, a.note, a.evidence_image_url
+		FROM class_students cs
+		JOIN schedules s ON s.class_id = cs.class_id
+		LEFT JOIN attendance a ON a.schedule_id = s.schedule_id AND a.student_id = cs.student_id
+		WHERE cs.student_id = ? AND COALESCE(cs.status, '') NOT IN ? AND s.start_time <= ?
+		ORDER BY s.start_time`, studentID, inactiveClassStudentStatuses, time.Now()).Scan(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	byClass := map[uuid.UUID][]TranscriptSession{}
+	for _, s := range sessions {
+		byClass[s.ClassID] = append(byClass[s.ClassID], s)
+	}
+
+	courses := make([]TranscriptCourse, len(eligibility))
+	for i, e := range eligibility {
+		courses[i] = TranscriptCourse{
+			ExamEligibility: e,
+			AttendanceRate:  attendanceRate(e.PresentCount, e.LateCount, e.HeldSessions),
+			Sessions:        byClass[e.ClassID],
+		}
+		if courses[i].Sessions == nil {
+			courses[i].Sessions = []TranscriptSession{}
+		}
+	}
+	return courses, nil
+}
+
+// GetMyAttendance trả về điểm danh của sinh viên đang đăng nhập ở mọi lớp: tỉ lệ đi học, từng buổi
+// kèm trạng thái và ảnh minh chứng, số buổi còn được vắng và kết quả xét dự thi.
+func GetMyAttendance(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	studentID := claimString(claims, "user_id")
+
+	courses, err := loadStudentTranscript(studentID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance"})
+	}
+
+	// Ghi log truy cập ảnh minh chứng
+	var accesses []biometricAccess
+	for _, course := range courses {
+		for _, s := range course.Sessions {
+			if s.EvidenceImageURL != nil && *s.EvidenceImageURL != "" && s.AttendanceID != nil {
+				accesses = append(accesses, biometricAccess{SubjectUserID: course.StudentID, ResourceID: s.AttendanceID.String()})
+			}
+		}
+	}
+	logBiometricAccess(c, "evidence_image", "read", accesses)
+
+	eligibility := make([]ExamEligibility, len(courses))
+	for i, course := range courses {
+		eligibility[i] = course.ExamEligibility
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"student_id": studentID,
+		"courses":    courses,
+		"counts":     eligibilityCounts(eligibility),
+	})
+}
+
+// ExportMyAttendanceTranscript tải bảng điểm danh cá nhân của sinh viên đang đăng nhập
+// (mặc định PDF, format=csv|xlsx cũng được hỗ trợ). File không chứa ảnh minh chứng.
+func ExportMyAttendanceTranscript(c echo.Context) error {
+	claims, _ := c.Get("user").(jwt.MapClaims)
+	studentID := claimString(claims, "user_id")
+
+	format := utils.ExportPDF
+	if c.QueryParam("format") != "" {
+		var err error
+		if format, err = exportFormat(c); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+	}
+	loc, err := reportLocation(c.QueryParam("tz"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid tz"})
+	}
+
+	var student struct {
+		StudentCode string
+		FullName    string
+	}
+	if err := config.DB.Raw(`SELECT s.student_code, u.first_name || ' ' || u.last_name AS full_name
+		FROM students s JOIN users u ON u.user_id = s.student_id WHERE s.student_id = ?`, studentID).
+		Scan(&student).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve student"})
+	}
+	courses, err := loadStudentTranscript(studentID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to retrieve attendance"})
+	}
+
+	meta := []utils.ExportMeta{
+		{Label: "Mã sinh viên", Value: student.StudentCode},
+		{Label: "Họ và tên", Value: student.FullName},
+		{Label: "Ngày xuất", Value: time.Now().In(loc).Format("02/01/2006 15:04")},
+	}
+	exporter, err := startExport(c, format, "attendance-transcript-"+student.StudentCode, "Bảng điểm danh cá nhân", meta)
+	if err != nil {
+		return finishExport(c, exporter, err)
+	}
+	err = exporter.Table("Tổng hợp theo học phần", []utils.ExportColumn{
+		{Title: "Học phần", Width: 26}, {Title: "Lớp", Width: 16}, {Title: "Buổi đã học", Width: 11},
+		{Title: "Có mặt", Width: 9}, {Title: "Đi muộn", Width: 9}, {Title: "Vắng", Width: 9},
+		{Title: "Vắng có phép", Width: 12}, {Title: "Tỉ lệ đi học (%)", Width: 14},
+		{Title: "Tỉ lệ vắng (%)", Width: 13}, {Title: "Còn được vắng", Width: 13}, {Title: "Kết quả", Width: 13},
+	})
+	for _, course := range courses {
+		if err != nil {
+			break
+		}
+		err = exporter.Row(course.CourseName, course.ClassName, course.HeldSessions, course.PresentCount,
+			course.LateCount, course.AbsentCount, course.ExcusedCount, course.AttendanceRate,
+			course.AbsencePercent, course.RemainingAbsences, eligibilityStatusLabels[course.Status])
+	}
+	for _, course := range courses {
+		if err != nil {
+			break
+		}
+		// Tên lớp đứng trước để tên sheet XLSX (tối đa 31 ký tự) vẫn phân biệt được các lớp
+		err = exporter.Table(course.ClassName+" - "+course.CourseName, []utils.ExportColumn{
+			{Title: "STT", Width: 6}, {Title: "Ngày học", Width: 16}, {Title: "Nội dung", Width: 30},
+			{Title: "Giờ điểm danh", Width: 16}, {Title: "Trạng thái", Width: 14}, {Title: "Ghi chú", Width: 30},
+		})
+		for i, s := range course.Sessions {
+			if err != nil {
+				break
+			}
+			status, attendedAt, note := "Chưa điểm danh", "", ""
+			if s.Status != nil {
+				if status = attendanceStatusLabels[*s.Status]; status == "" {
+					status = *s.Status
+				}
+			}
+			if s.AttendanceTime != nil && !s.AttendanceTime.IsZero() {
+				attendedAt = s.AttendanceTime.In(loc).Format("02/01/2006 15:04")
+			}
+			if s.Note != nil {
+				note = *s.Note
+			}
+			err = exporter.Row(i+1, s.StartTime.In(loc).Format("02/01/2006 15:04"), s.Topic, attendedAt, status, note)
+		}
+	}
+	return finishExport(c, exporter, err)
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"cms-backend/config"
+	"cms-backend/controllers"
 	"cms-backend/models"
 	"cms-backend/routes"
-	"fmt"
 	"log"
 	"os"
+	_ "time/tzdata" // Nhúng dữ liệu múi giờ IANA cho báo cáo, không phụ thuộc hệ điều hành
 
 	"github.com/joho/godotenv"
 	"github.com/labstack/echo/v4"
@@ -19,31 +20,71 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	// Kiểm tra giá trị của JWT_SECRET để chắc chắn rằng nó đã được tải
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET is required but not set in the environment variables")
-	}
-	fmt.Println("Loaded JWT_SECRET:", jwtSecret)
+	// Nạp khoá ký JWT (RS256/EdDSA) dùng chung cho toàn bộ ứng dụng
+	config.InitSigner()
 
 	// Kết nối đến cơ sở dữ liệu
 	config.ConnectDB()
 
+	// Khởi tạo dịch vụ gửi email (SMTP hoặc outbox)
+	config.InitMailer()
+
 	// Thực hiện AutoMigration cho các model
 	if err := config.DB.AutoMigrate(
 		&models.User{},
 		&models.Student{},
 		&models.Lecturer{},
 		&models.Admin{},
+		&models.RefreshToken{},
+		&models.PasswordResetToken{},
+		&models.LoginThrottle{},
+		&models.SecurityEvent{},
+		&models.UserMFA{},
+		&models.MFARecoveryCode{},
+		&models.MFAPolicy{},
+		&models.FaceModel{},
+		&models.FaceEmbedding{},
+		&models.BiometricConsent{},
+		&models.BiometricAccessLog{},
+		&models.BiometricErasure{},
+		&models.RecognitionEvent{},
+		&models.AttendancePolicy{},
+		&models.LeaveRequest{},
+		&models.LeaveRequestSchedule{},
+		&models.LeaveRequestDocument{},
+		&models.AttendanceAppeal{},
+		&models.AttendanceAppealEvent{},
+		&models.CheckInSession{},
+		&models.AttendanceLock{},
+		&models.AttendanceLockEvent{},
+		&models.AttendanceVersion{},
+		&models.ExamEligibilitySignoff{},
 		// &models.Class{},
 		// &models.Course{},
 	); err != nil {
 		log.Fatalf("Error during database migration: %v", err)
 	}
 
+	// Bổ sung ràng buộc cho các bảng điểm danh không do AutoMigrate quản lý
+	config.MigrateAttendanceSchema()
+
+	// Chuyển embedding cũ (cột face_embedding) sang bảng face_embeddings
+	if err := controllers.MigrateLegacyFaceEmbeddings(); err != nil {
+		log.Fatalf("Error migrating legacy face embeddings: %v", err)
+	}
+
+	// Tự động đánh vắng sinh viên không có mặt khi buổi học kết thúc
+	controllers.StartAbsenceJob()
+
+	// Chốt điểm danh khi hết thời gian rà soát
+	controllers.StartAttendanceFinalizationJob()
+
 	// Khởi tạo một instance của Echo
 	e := echo.New()
 
+	// Không tin X-Forwarded-For/X-Real-IP do client tự gửi (xem TRUSTED_PROXIES)
+	e.IPExtractor = config.IPExtractor()
+
 	// Cấu hình middleware của Echo
 	e.Use(echomiddleware.Logger())  // Log mỗi request
 	e.Use(echomiddleware.Recover()) // Giúp ứng dụng không bị sập khi có lỗi
@@ -1,44 +1,75 @@
 package middleware
 
 import (
+	"cms-backend/utils"
 	"net/http"
-	"os"
 	"strings"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// ownerRoles ánh xạ tên tham số định danh (path/query) sang role sở hữu nó.
+// Ví dụ: một lecturer chỉ được truyền lecturer_id của chính mình.
+var ownerRoles = map[string]string{
+	"lecturer_id": "lecturer",
+	"student_id":  "student",
+}
 
+// JWTAuthMiddleware chỉ chấp nhận access token, refresh token không dùng được để gọi API.
 func JWTAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		tokenString := c.Request().Header.Get("Authorization")
-		if tokenString == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Missing token"})
-		}
+	return TokenAuthMiddleware(utils.TokenTypeAccess)(next)
+}
 
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+// TokenAuthMiddleware xác thực JWT có claim "typ" thuộc một trong các loại tokenTypes.
+func TokenAuthMiddleware(tokenTypes ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			tokenString := c.Request().Header.Get("Authorization")
+			if tokenString == "" {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Missing token"})
+			}
 
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+			tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+			for _, tokenType := range tokenTypes {
+				if claims, err := utils.ParseToken(tokenString, tokenType); err == nil {
+					c.Set("user", claims)
+					return next(c)
+				}
+			}
 
-		if err != nil || !token.Valid {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid token"})
 		}
+	}
+}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Invalid token claims"})
-		}
+// RoleMiddleware chỉ cho phép các request có role nằm trong danh sách allowedRoles.
+func RoleMiddleware(allowedRoles ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, ok := c.Get("user").(jwt.MapClaims)
+			if !ok {
+				return c.JSON(http.StatusForbidden, map[string]string{"message": "No user claims found"})
+			}
 
-		c.Set("user", claims)
-		return next(c)
+			role, _ := claims["role"].(string)
+			for _, allowed := range allowedRoles {
+				if role == allowed {
+					return next(c)
+				}
+			}
+
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Access denied: insufficient role"})
+		}
 	}
 }
 
-func RoleMiddleware(requiredRole string) echo.MiddlewareFunc {
+// OwnershipMiddleware kiểm tra các tham số định danh (lecturer_id, student_id) trong path
+// và query string so với user_id trong token. Tham số tương ứng với role của người gọi
+// là bắt buộc, để không thể bỏ trống nhằm lấy dữ liệu của tất cả mọi người.
+// Admin được bỏ qua kiểm tra này.
+func OwnershipMiddleware(params ...string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			claims, ok := c.Get("user").(jwt.MapClaims)
@@ -46,9 +77,29 @@ func RoleMiddleware(requiredRole string) echo.MiddlewareFunc {
 				return c.JSON(http.StatusForbidden, map[string]string{"message": "No user claims found"})
 			}
 
-			role, ok := claims["role"].(string)
-			if !ok || role != requiredRole {
-				return c.JSON(http.StatusForbidden, map[string]string{"message": "Access denied: insufficient role"})
+			role, _ := claims["role"].(string)
+			if role == "admin" {
+				return next(c)
+			}
+			userID, _ := claims["user_id"].(string)
+
+			for _, name := range params {
+				if ownerRoles[name] != role {
+					continue
+				}
+				found := false
+				for _, value := range []string{c.Param(name), c.QueryParam(name)} {
+					if value == "" {
+						continue
+					}
+					if !strings.EqualFold(value, userID) {
+						return c.JSON(http.StatusForbidden, map[string]string{"message": "Access denied: resource belongs to another user"})
+					}
+					found = true
+				}
+				if !found {
+					return c.JSON(http.StatusForbidden, map[string]string{"message": "Access denied: missing " + name})
+				}
 			}
 
 			return next(c)
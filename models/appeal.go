@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Trạng thái đơn phúc khảo điểm danh
+const (
+	AppealStatusPending  = "pending"
+	AppealStatusAccepted = "accepted"
+	AppealStatusRejected = "rejected"
+)
+
+// AttendanceAppeal là đơn phúc khảo của sinh viên cho một bản ghi điểm danh
+// (ví dụ camera không nhận ra sinh viên nên bị đánh vắng).
+type AttendanceAppeal struct {
+	AppealID        uuid.UUID               `json:"appeal_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	AttendanceID    uuid.UUID               `json:"attendance_id" gorm:"type:uuid;not null;index"`
+	StudentID       uuid.UUID               `json:"student_id" gorm:"type:uuid;not null;index"`
+	ScheduleID      uuid.UUID               `json:"schedule_id" gorm:"type:uuid;not null;index"`
+	ClassID         uuid.UUID               `json:"class_id" gorm:"type:uuid;not null;index"`
+	RequestedStatus string                  `json:"requested_status" gorm:"type:varchar(20);not null"`
+	Reason          string                  `json:"reason" gorm:"type:text;not null"`
+	Status          string                  `json:"status" gorm:"type:varchar(20);not null;default:'pending';index"`
+	DecidedBy       *uuid.UUID              `json:"decided_by" gorm:"type:uuid"`
+	DecidedAt       *time.Time              `json:"decided_at"`
+	CreatedAt       time.Time               `json:"created_at"`
+	UpdatedAt       time.Time               `json:"updated_at"`
+	Events          []AttendanceAppealEvent `json:"events,omitempty" gorm:"foreignKey:AppealID"`
+}
+
+// AttendanceAppealEvent là một mốc trong lịch sử xử lý đơn phúc khảo. Bảng chỉ được ghi thêm,
+// không sửa/xoá, để phục vụ kiểm tra lại các quyết định.
+type AttendanceAppealEvent struct {
+	EventID        uuid.UUID  `json:"event_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	AppealID       uuid.UUID  `json:"appeal_id" gorm:"type:uuid;not null;index"`
+	ActorID        *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	ActorRole      string     `json:"actor_role" gorm:"type:varchar(50)"`
+	Action         string     `json:"action" gorm:"type:varchar(30);not null"` // submitted, accepted, rejected
+	Comment        *string    `json:"comment"`
+	PreviousStatus *string    `json:"previous_status"` // Trạng thái điểm danh trước khi sửa
+	NewStatus      *string    `json:"new_status"`      // Trạng thái điểm danh sau khi sửa
+	CreatedAt      time.Time  `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Các trạng thái điểm danh
+const (
+	AttendanceStatusPresent = "present"
+	AttendanceStatusLate    = "late"
+	AttendanceStatusAbsent  = "absent"
+	AttendanceStatusExcused = "excused" // Vắng có phép (đơn xin nghỉ đã được duyệt)
+)
+
+// Kênh ghi nhận điểm danh
+const (
+	AttendanceChannelCamera  = "camera"
+	AttendanceChannelQR      = "qr"
+	AttendanceChannelManual  = "manual"
+	AttendanceChannelLeave   = "leave_request"
+	AttendanceChannelSystem  = "system" // Job tự động đánh vắng
+	AttendanceChannelAppeal  = "appeal"
+	AttendanceChannelErasure = "erasure" // Xoá ảnh minh chứng theo quyền được xoá dữ liệu
+)
+
+// Attendance ánh xạ bảng attendance có sẵn (không nằm trong AutoMigrate).
+type Attendance struct {
+	AttendanceID     uuid.UUID `json:"attendance_id" gorm:"type:uuid;primaryKey"`
+	ScheduleID       uuid.UUID `json:"schedule_id" gorm:"type:uuid"`
+	StudentID        uuid.UUID `json:"student_id" gorm:"type:uuid"`
+	AttendanceTime   time.Time `json:"attendance_time"`
+	Status           string    `json:"status"` // "present", "late", "absent", "excused"
+	EvidenceImageURL *string   `json:"evidence_image_url"`
+	Note             *string   `json:"note"`
+	Channel          *string   `json:"channel"` // Kênh ghi nhận trạng thái hiện tại (NULL với dữ liệu cũ)
+}
+
+func (Attendance) TableName() string {
+	return "attendance"
+}
+
+// RecognitionEvent là một sự kiện nhận dạng do camera gửi lên, lưu lại để đối soát.
+type RecognitionEvent struct {
+	EventID          uuid.UUID  `json:"event_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	CameraID         uuid.UUID  `json:"camera_id" gorm:"type:uuid;not null;index"`
+	ScheduleID       *uuid.UUID `json:"schedule_id" gorm:"type:uuid;index"`
+	StudentID        *uuid.UUID `json:"student_id" gorm:"type:uuid;index"`
+	CapturedAt       time.Time  `json:"captured_at" gorm:"not null"`
+	Confidence       float64    `json:"confidence"`
+	Distance         *float64   `json:"distance"` // Khoảng cách cosine nếu danh tính được xác định từ embedding
+	EvidenceImageURL *string    `json:"evidence_image_url"`
+	Result           string     `json:"result" gorm:"type:varchar(30);not null"` // applied, low_confidence, unresolved, no_schedule
+	AttendanceID     *uuid.UUID `json:"attendance_id" gorm:"type:uuid"`
+	CreatedAt        time.Time  `json:"created_at"`
+}
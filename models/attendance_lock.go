@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Trạng thái chốt điểm danh của một buổi học
+const (
+	AttendanceStateOpen        = "open"         // Buổi học chưa kết thúc
+	AttendanceStateUnderReview = "under_review" // Đã kết thúc, còn trong thời gian rà soát
+	AttendanceStateFinalized   = "finalized"    // Đã chốt, không cho sửa
+)
+
+// AttendanceLock lưu trạng thái chốt điểm danh của một buổi học. Buổi học chưa có bản ghi
+// được coi là open/under_review theo thời gian; job nền tạo bản ghi khi hết thời gian rà soát.
+type AttendanceLock struct {
+	ScheduleID    uuid.UUID  `json:"schedule_id" gorm:"type:uuid;primaryKey"`
+	FinalizedAt   *time.Time `json:"finalized_at"`
+	FinalizedBy   *uuid.UUID `json:"finalized_by" gorm:"type:uuid"` // NULL khi job tự chốt
+	ReopenedUntil *time.Time `json:"reopened_until"`                // Hạn rà soát mới sau khi admin mở khoá
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// AttendanceLockEvent ghi lại mỗi lần chốt hoặc mở khoá điểm danh của một buổi học.
+type AttendanceLockEvent struct {
+	EventID    uuid.UUID  `json:"event_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ScheduleID uuid.UUID  `json:"schedule_id" gorm:"type:uuid;not null;index"`
+	Action     string     `json:"action" gorm:"type:varchar(20);not null"` // finalized, unlocked
+	ActorID    *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	Reason     *string    `json:"reason"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
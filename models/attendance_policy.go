@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttendancePolicy quy định cách tính đi muộn/vắng mặt. Chính sách gắn với khoá học (CourseID)
+// và có thể được ghi đè cho từng lớp (ClassID); mỗi bản ghi chỉ đặt một trong hai.
+type AttendancePolicy struct {
+	PolicyID              uuid.UUID  `json:"policy_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	CourseID              *uuid.UUID `json:"course_id" gorm:"type:uuid;uniqueIndex"`
+	ClassID               *uuid.UUID `json:"class_id" gorm:"type:uuid;uniqueIndex"`
+	GraceMinutes          int        `json:"grace_minutes" gorm:"not null;default:0"`           // Số phút sau giờ bắt đầu vẫn tính là có mặt
+	AbsentAfterMinutes    int        `json:"absent_after_minutes" gorm:"not null;default:0"`    // Đến sau số phút này tính là vắng (0 = không giới hạn)
+	LateAbsenceWeight     float64    `json:"late_absence_weight" gorm:"not null;default:0"`     // Một lần đi muộn tính bằng bao nhiêu buổi vắng (0..1)
+	WarningAbsencePercent float64    `json:"warning_absence_percent" gorm:"not null;default:0"` // Tỉ lệ vắng (%) bắt đầu cảnh báo cấm thi (0 = mặc định)
+	MaxAbsencePercent     float64    `json:"max_absence_percent" gorm:"not null;default:0"`     // Tỉ lệ vắng (%) vượt mức này bị cấm thi (0 = mặc định)
+	UpdatedBy             *uuid.UUID `json:"updated_by" gorm:"type:uuid"`
+	CreatedAt             time.Time  `json:"created_at"`
+	UpdatedAt             time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttendanceVersion là một phiên bản của bản ghi điểm danh: ảnh chụp trước và sau mỗi lần thay đổi,
+// người thực hiện và nguồn thay đổi. Bảng chỉ được ghi thêm.
+type AttendanceVersion struct {
+	VersionID    uuid.UUID       `json:"version_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	Seq          int64           `json:"seq" gorm:"autoIncrement;uniqueIndex"` // Thứ tự toàn cục, dùng làm con trỏ cho luồng realtime
+	AttendanceID uuid.UUID       `json:"attendance_id" gorm:"type:uuid;not null;uniqueIndex:idx_attendance_version"`
+	Version      int             `json:"version" gorm:"not null;uniqueIndex:idx_attendance_version"`
+	ScheduleID   uuid.UUID       `json:"schedule_id" gorm:"type:uuid;not null;index"` // Buổi học sau khi thay đổi
+	StudentID    uuid.UUID       `json:"student_id" gorm:"type:uuid;not null;index"`  // Sinh viên sau khi thay đổi
+	Source       string          `json:"source" gorm:"type:varchar(20);not null"`     // manual, camera, qr, appeal, leave_request, system, erasure
+	ActorID      *uuid.UUID      `json:"actor_id" gorm:"type:uuid"`
+	ActorRole    string          `json:"actor_role" gorm:"type:varchar(50)"`
+	Previous     json.RawMessage `json:"previous" gorm:"type:jsonb"` // NULL khi bản ghi mới được tạo
+	Current      json.RawMessage `json:"current" gorm:"type:jsonb;not null"`
+	CreatedAt    time.Time       `json:"created_at" gorm:"index"`
+}
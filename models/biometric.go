@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BiometricConsent là bản ghi đồng ý (hoặc rút lại đồng ý) xử lý dữ liệu sinh trắc học của một user.
+// Mỗi lần thay đổi tạo một bản ghi mới; bản ghi mới nhất là trạng thái hiện tại.
+type BiometricConsent struct {
+	ConsentID     uuid.UUID  `json:"consent_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID        uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Granted       bool       `json:"granted" gorm:"not null"`
+	PolicyVersion string     `json:"policy_version" gorm:"type:varchar(50)"`
+	RecordedBy    *uuid.UUID `json:"recorded_by" gorm:"type:uuid"`
+	IPAddress     string     `json:"ip_address" gorm:"type:varchar(64)"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// BiometricAccessLog ghi lại mỗi lần đọc dữ liệu sinh trắc học (embedding, ảnh minh chứng).
+type BiometricAccessLog struct {
+	LogID         uuid.UUID  `json:"log_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ActorID       *uuid.UUID `json:"actor_id" gorm:"type:uuid;index"`
+	ActorRole     string     `json:"actor_role" gorm:"type:varchar(50)"`
+	SubjectUserID *uuid.UUID `json:"subject_user_id" gorm:"type:uuid;index"`
+	ResourceType  string     `json:"resource_type" gorm:"type:varchar(50);not null"` // face_embedding, evidence_image, surveillance_snapshot
+	ResourceID    string     `json:"resource_id" gorm:"type:varchar(255)"`
+	Action        string     `json:"action" gorm:"type:varchar(50);not null"` // list, identify, read, erase
+	IPAddress     string     `json:"ip_address" gorm:"type:varchar(64)"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"index"`
+}
+
+// BiometricErasure lưu kết quả một lần xoá dữ liệu sinh trắc học theo yêu cầu (quyền được xoá).
+type BiometricErasure struct {
+	ErasureID         uuid.UUID  `json:"erasure_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID            uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	RequestedBy       *uuid.UUID `json:"requested_by" gorm:"type:uuid"`
+	Reason            string     `json:"reason"`
+	EmbeddingsDeleted int64      `json:"embeddings_deleted"`
+	EvidenceCleared   int64      `json:"evidence_cleared"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
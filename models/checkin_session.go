@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CheckInSession là phiên điểm danh bằng mã QR xoay vòng của một buổi học, dùng khi camera
+// nhận dạng không hoạt động. Secret dùng để ký token trong mã QR và không bao giờ trả ra API.
+type CheckInSession struct {
+	SessionID       uuid.UUID  `json:"session_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ScheduleID      uuid.UUID  `json:"schedule_id" gorm:"type:uuid;not null;index"`
+	Secret          []byte     `json:"-" gorm:"not null"`
+	RotationSeconds int        `json:"rotation_seconds" gorm:"not null"`
+	OpensAt         time.Time  `json:"opens_at" gorm:"not null"`
+	ClosesAt        time.Time  `json:"closes_at" gorm:"not null"`
+	ClosedAt        *time.Time `json:"closed_at"` // Đóng sớm bởi giảng viên
+	CreatedBy       uuid.UUID  `json:"created_by" gorm:"type:uuid;not null"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Kết quả xét điều kiện dự thi theo tỉ lệ vắng
+const (
+	EligibilityOK      = "ok"
+	EligibilityWarning = "warning" // Sắp vượt ngưỡng vắng cho phép
+	EligibilityBarred  = "barred"  // Vắng vượt ngưỡng, không được dự thi
+)
+
+// ExamEligibilitySignoff là danh sách xét dự thi của một lớp đã được giảng viên xác nhận.
+// Danh sách được lưu nguyên tại thời điểm ký để không thay đổi theo dữ liệu điểm danh sau đó.
+type ExamEligibilitySignoff struct {
+	SignoffID     uuid.UUID       `json:"signoff_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ClassID       uuid.UUID       `json:"class_id" gorm:"type:uuid;not null;index"`
+	SignedBy      uuid.UUID       `json:"signed_by" gorm:"type:uuid;not null"`
+	Note          string          `json:"note" gorm:"type:text"`
+	TotalStudents int             `json:"total_students"`
+	WarningCount  int             `json:"warning_count"`
+	BarredCount   int             `json:"barred_count"`
+	Students      json.RawMessage `json:"students,omitempty" gorm:"type:jsonb;not null"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	pgvector "github.com/pgvector/pgvector-go"
+)
+
+// FaceModel là một phiên bản mô hình nhận dạng khuôn mặt sinh ra embedding.
+// Tại mỗi thời điểm chỉ có một mô hình đang hoạt động (IsActive).
+type FaceModel struct {
+	FaceModelID  uuid.UUID `json:"face_model_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ModelName    string    `json:"model_name" gorm:"type:varchar(100);not null;uniqueIndex:idx_face_models_name_version"`
+	ModelVersion string    `json:"model_version" gorm:"type:varchar(50);not null;uniqueIndex:idx_face_models_name_version"`
+	Dimension    int       `json:"dimension" gorm:"not null"`
+	IsActive     bool      `json:"is_active" gorm:"not null;default:false"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// FaceEmbedding là một vector khuôn mặt đã đăng ký của một người (mỗi người có thể có nhiều vector).
+// Embedding chỉ được so sánh với embedding cùng ModelName/ModelVersion.
+type FaceEmbedding struct {
+	EmbeddingID  uuid.UUID       `json:"embedding_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID       uuid.UUID       `json:"user_id" gorm:"type:uuid;not null;index"`
+	Embedding    pgvector.Vector `json:"-" gorm:"type:vector;not null"`
+	ModelName    string          `json:"model_name" gorm:"type:varchar(100);not null;default:'unknown';index:idx_face_embeddings_model"`
+	ModelVersion string          `json:"model_version" gorm:"type:varchar(50);not null;default:'unknown';index:idx_face_embeddings_model"`
+	Dimension    int             `json:"dimension" gorm:"not null;default:512"`
+	Source       string          `json:"source" gorm:"type:varchar(50);not null"` // Nguồn thu: camera, upload, enrollment_app, legacy, ...
+	QualityScore float64         `json:"quality_score"`
+	IsPrimary    bool            `json:"is_primary" gorm:"not null;default:false"` // Embedding chính của người đó trong cùng một mô hình
+	CreatedAt    time.Time       `json:"created_at"`
+}
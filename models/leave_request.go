@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Trạng thái đơn xin nghỉ
+const (
+	LeaveStatusPending  = "pending"
+	LeaveStatusApproved = "approved"
+	LeaveStatusRejected = "rejected"
+)
+
+// LeaveRequest là đơn xin nghỉ của sinh viên cho một hoặc nhiều buổi học của cùng một lớp.
+type LeaveRequest struct {
+	RequestID  uuid.UUID              `json:"request_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	StudentID  uuid.UUID              `json:"student_id" gorm:"type:uuid;not null;index"`
+	ClassID    uuid.UUID              `json:"class_id" gorm:"type:uuid;not null;index"`
+	Reason     string                 `json:"reason" gorm:"type:text;not null"`
+	Status     string                 `json:"status" gorm:"type:varchar(20);not null;default:'pending';index"`
+	ReviewedBy *uuid.UUID             `json:"reviewed_by" gorm:"type:uuid"`
+	ReviewedAt *time.Time             `json:"reviewed_at"`
+	ReviewNote *string                `json:"review_note"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+	Schedules  []LeaveRequestSchedule `json:"schedules" gorm:"foreignKey:RequestID;constraint:OnDelete:CASCADE"`
+	Documents  []LeaveRequestDocument `json:"documents" gorm:"foreignKey:RequestID;constraint:OnDelete:CASCADE"`
+}
+
+// LeaveRequestSchedule là một buổi học được xin nghỉ trong đơn.
+type LeaveRequestSchedule struct {
+	RequestID  uuid.UUID `json:"request_id" gorm:"type:uuid;primaryKey"`
+	ScheduleID uuid.UUID `json:"schedule_id" gorm:"type:uuid;primaryKey;index"`
+}
+
+// LeaveRequestDocument là tài liệu đính kèm đơn xin nghỉ (giấy khám bệnh, ...).
+type LeaveRequestDocument struct {
+	DocumentID  uuid.UUID `json:"document_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	RequestID   uuid.UUID `json:"request_id" gorm:"type:uuid;not null;index"`
+	FileName    string    `json:"file_name" gorm:"type:varchar(255);not null"`
+	ContentType string    `json:"content_type" gorm:"type:varchar(100)"`
+	Size        int64     `json:"size"`
+	StoragePath string    `json:"-" gorm:"type:varchar(500);not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
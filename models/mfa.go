@@ -0,0 +1,39 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UserMFA lưu cấu hình xác thực hai lớp (TOTP) của một user.
+type UserMFA struct {
+	UserID       uuid.UUID  `json:"user_id" gorm:"type:uuid;primaryKey"`
+	Secret       string     `json:"-" gorm:"type:varchar(64);not null"`
+	Enabled      bool       `json:"enabled" gorm:"not null;default:false"`
+	EnabledAt    *time.Time `json:"enabled_at"`
+	LastUsedStep int64      `json:"-"` // Chu kỳ TOTP đã dùng gần nhất, chống dùng lại mã
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+func (UserMFA) TableName() string {
+	return "user_mfa"
+}
+
+// MFARecoveryCode là mã khôi phục dùng một lần, lưu dưới dạng hash.
+type MFARecoveryCode struct {
+	CodeID    uuid.UUID  `json:"code_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	CodeHash  string     `json:"-" gorm:"type:varchar(64);not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFAPolicy cho biết một role có bắt buộc bật xác thực hai lớp hay không.
+type MFAPolicy struct {
+	Role      string     `json:"role" gorm:"type:varchar(50);primaryKey"`
+	Required  bool       `json:"required" gorm:"not null;default:false"`
+	UpdatedBy *uuid.UUID `json:"updated_by" gorm:"type:uuid"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshToken lưu refresh token phía server dưới dạng hash.
+// Các token được sinh ra từ cùng một lần đăng nhập dùng chung FamilyID,
+// nhờ đó có thể thu hồi cả chuỗi khi phát hiện token bị dùng lại.
+type RefreshToken struct {
+	TokenID    uuid.UUID  `json:"token_id" gorm:"type:uuid;primaryKey"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	FamilyID   uuid.UUID  `json:"family_id" gorm:"type:uuid;not null;index"`
+	TokenHash  string     `json:"-" gorm:"type:varchar(64);unique;not null"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	ReplacedBy *uuid.UUID `json:"replaced_by" gorm:"type:uuid"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
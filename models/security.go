@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LoginThrottle theo dõi số lần đăng nhập thất bại theo khoá ("user:<id>" hoặc "ip:<addr>").
+type LoginThrottle struct {
+	ThrottleKey  string     `json:"throttle_key" gorm:"type:varchar(255);primaryKey"`
+	FailedCount  int        `json:"failed_count" gorm:"not null;default:0"`
+	LastFailedAt time.Time  `json:"last_failed_at"`
+	BlockedUntil *time.Time `json:"blocked_until"` // Thời điểm hết backoff
+	LockedUntil  *time.Time `json:"locked_until"`  // Thời điểm hết khoá tài khoản
+}
+
+// SecurityEvent ghi lại các sự kiện bảo mật (khoá tài khoản, mở khoá, ...).
+type SecurityEvent struct {
+	EventID   uuid.UUID  `json:"event_id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    *uuid.UUID `json:"user_id" gorm:"type:uuid;index"`
+	ActorID   *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	EventType string     `json:"event_type" gorm:"type:varchar(50);not null;index"`
+	IPAddress string     `json:"ip_address" gorm:"type:varchar(64)"`
+	Detail    string     `json:"detail"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -19,6 +19,6 @@ func GenerateStudentCode() string {
 type Student struct {
 	StudentID     uuid.UUID `json:"student_id" gorm:"type:uuid;primaryKey"`
 	StudentCode   string    `json:"student_code" gorm:"type:varchar(100);unique;not null"`
-	FaceEmbedding pgvector.Vector `json:"face_embedding" gorm:"type:vector(512)"`
+	FaceEmbedding pgvector.Vector `json:"-" gorm:"type:vector(512)"`
 	User          User      `gorm:"foreignKey:StudentID;references:UserID"`
 }
\ No newline at end of file
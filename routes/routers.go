@@ -2,40 +2,172 @@ package routes
 
 import (
 	"cms-backend/controllers"
+	"cms-backend/middleware"
+	"cms-backend/utils"
 
 	"github.com/labstack/echo/v4"
 )
 
 func SetupRoutes(e *echo.Echo) {
+	// Khoá công khai để xác thực JWT
+	e.GET("/.well-known/jwks.json", controllers.GetJWKS)
+
+	// Các route xác thực công khai (không cần access token)
+	e.POST("/auth/login", controllers.LoginUser)
+	e.POST("/auth/refresh", controllers.RefreshToken)
+	e.POST("/auth/logout", controllers.Logout)
+	e.POST("/auth/forgot-password", controllers.ForgotPassword)
+	e.POST("/auth/reset-password", controllers.ResetPassword)
+
+	// Xác thực hai lớp: dùng token mfa_pending nhận được sau bước đăng nhập bằng mật khẩu
+	mfaPending := middleware.TokenAuthMiddleware(utils.TokenTypeMFAPending)
+	mfaEnrollment := middleware.TokenAuthMiddleware(utils.TokenTypeAccess, utils.TokenTypeMFAPending)
+	e.POST("/auth/mfa/verify", controllers.VerifyMFA, mfaPending)
+	e.POST("/auth/mfa/enroll", controllers.EnrollMFA, mfaEnrollment)
+	e.POST("/auth/mfa/enroll/confirm", controllers.ConfirmMFAEnrollment, mfaEnrollment)
+
+	// Các route còn lại đều yêu cầu JWT hợp lệ
+	api := e.Group("", middleware.JWTAuthMiddleware)
+
+	// Các middleware phân quyền dùng chung
+	staff := middleware.RoleMiddleware("admin", "lecturer")
+	everyone := middleware.RoleMiddleware("admin", "lecturer", "student")
+	ownsLecturer := middleware.OwnershipMiddleware("lecturer_id")
+	adminOnly := middleware.RoleMiddleware("admin")
+	// Tài khoản máy (role "service") dùng cho các dịch vụ camera AI
+	recognition := middleware.RoleMiddleware("admin", "lecturer", "service")
+
+	// Phiên đăng nhập và hồ sơ cá nhân
+	api.POST("/auth/register", controllers.RegisterUser, adminOnly)
+	api.POST("/auth/logout-all", controllers.LogoutAll)
+	api.GET("/auth/me", controllers.GetCurrentUser)
+	api.PUT("/auth/profile", controllers.UpdateProfile)
+	api.POST("/auth/change-password", controllers.ChangePassword)
+	api.POST("/auth/mfa/recovery-codes", controllers.RegenerateRecoveryCodes)
+	api.POST("/auth/mfa/disable", controllers.DisableMFA)
+	api.POST("/admin/users/:id/unlock", controllers.UnlockAccount, adminOnly)
+	api.GET("/admin/mfa-policies", controllers.GetMFAPolicies, adminOnly)
+	api.PUT("/admin/mfa-policies/:role", controllers.UpdateMFAPolicy, adminOnly)
 
 	// ================================================================
-	e.GET("/classes/:lecturer_id", controllers.GetClassesByLecturer)
-	e.GET("/attendance-summary", controllers.AttendanceSummaryHandler)
-	e.GET("/attendance-detail", controllers.GetAttendanceDetails)
-	e.POST("/update-attendance", controllers.UpdateAttendance)
-	e.GET("/attendance-report/:lecturer_id", controllers.GetAttendanceReport)
-	e.GET("/students-in-class/:lecturer_id", controllers.GetStudentsInClass)
-	e.GET("/students-in-class/:lecturer_id", controllers.GetStudentsInClass)
-	e.PUT("/update/student/:id", controllers.UpdateStudent)
-	e.DELETE("/del-student-from-class/:student_id/:class_id", controllers.DeleteStudentFromClass)
-	e.GET("/check-student-existence/:studentCode", controllers.CheckStudentExistence)
+	// Dữ liệu theo giảng viên: lecturer chỉ xem được dữ liệu của chính mình
+	api.GET("/classes/:lecturer_id", controllers.GetClassesByLecturer, staff, ownsLecturer)
+	api.GET("/attendance-summary", controllers.AttendanceSummaryHandler, staff, ownsLecturer)
+	api.GET("/attendance-detail", controllers.GetAttendanceDetails, staff, ownsLecturer)
+	api.GET("/attendance-detail/export", controllers.ExportAttendanceDetails, staff, ownsLecturer)
+	api.POST("/update-attendance", controllers.UpdateAttendance, staff)
+	api.GET("/attendance-report/:lecturer_id", controllers.GetAttendanceReport, staff, ownsLecturer)
+
+	// Đơn xin nghỉ: sinh viên gửi, giảng viên phụ trách lớp duyệt
+	studentOnly := middleware.RoleMiddleware("student")
+	api.POST("/leave-requests", controllers.SubmitLeaveRequest, studentOnly)
+	api.GET("/me/leave-requests", controllers.GetMyLeaveRequests, studentOnly)
+	api.DELETE("/leave-requests/:id", controllers.CancelLeaveRequest, studentOnly)
+	api.GET("/leave-requests", controllers.GetLeaveRequestQueue, staff)
+	api.PUT("/leave-requests/:id/approve", controllers.ApproveLeaveRequest, staff)
+	api.PUT("/leave-requests/:id/reject", controllers.RejectLeaveRequest, staff)
+	api.GET("/leave-requests/:id/documents/:document_id", controllers.DownloadLeaveDocument, everyone)
+
+	// Phúc khảo điểm danh: sinh viên gửi, giảng viên đối chiếu bằng chứng rồi chấp nhận/từ chối
+	api.POST("/attendance/:attendance_id/appeals", controllers.SubmitAppeal, studentOnly)
+	api.GET("/me/appeals", controllers.GetMyAppeals, studentOnly)
+	api.GET("/appeals", controllers.GetAppealQueue, staff)
+	api.GET("/appeals/:id", controllers.GetAppealDetail, everyone)
+	api.PUT("/appeals/:id/accept", controllers.AcceptAppeal, staff)
+	api.PUT("/appeals/:id/reject", controllers.RejectAppeal, staff)
+
+	// Bảng điểm danh cá nhân của sinh viên ở mọi lớp
+	api.GET("/me/attendance", controllers.GetMyAttendance, studentOnly)
+	api.GET("/me/attendance/transcript", controllers.ExportMyAttendanceTranscript, studentOnly)
+
+	// Điểm danh dự phòng bằng mã QR xoay vòng khi camera không hoạt động
+	api.POST("/schedules/:schedule_id/checkin-session", controllers.OpenCheckInSession, staff)
+	api.GET("/schedules/:schedule_id/checkin-session/qr", controllers.GetCheckInQR, staff)
+	api.DELETE("/schedules/:schedule_id/checkin-session", controllers.CloseCheckInSession, staff)
+	api.POST("/attendance/check-in", controllers.CheckInWithQR, studentOnly)
+
+	// Lịch sử thay đổi điểm danh
+	api.GET("/attendance/:attendance_id/history", controllers.GetAttendanceHistory, everyone)
+	api.GET("/schedules/:schedule_id/attendance-history", controllers.GetScheduleAttendanceHistory, staff)
+
+	// Luồng điểm danh realtime (Server-Sent Events) của một buổi học
+	api.GET("/schedules/:schedule_id/attendance-feed", controllers.StreamAttendanceFeed, staff)
+
+	// Đối soát bộ đếm người với điểm danh
+	api.GET("/schedules/:schedule_id/reconciliation", controllers.GetScheduleReconciliation, staff)
+	api.GET("/classes/:class_id/reconciliation", controllers.GetClassReconciliation, staff)
+
+	// Danh sách ký tên điểm danh (PDF) cho phòng học không có camera
+	api.GET("/schedules/:schedule_id/attendance-sheet", controllers.GetAttendanceSheet, staff)
+
+	// Xét điều kiện dự thi theo tỉ lệ vắng
+	api.GET("/classes/:class_id/exam-eligibility", controllers.GetClassExamEligibility, staff)
+	api.GET("/courses/:course_id/exam-eligibility", controllers.GetCourseExamEligibility, staff)
+	api.POST("/classes/:class_id/exam-eligibility/sign-off", controllers.SignOffExamEligibility, staff)
+	api.GET("/classes/:class_id/exam-eligibility/sign-offs", controllers.GetExamEligibilitySignoffs, staff)
+
+	// Chốt điểm danh: open -> under_review -> finalized; chỉ admin được mở khoá (kèm lý do)
+	api.GET("/schedules/:schedule_id/attendance-state", controllers.GetAttendanceState, staff)
+	api.POST("/schedules/:schedule_id/finalize", controllers.FinalizeAttendance, staff)
+	api.POST("/schedules/:schedule_id/unlock", controllers.UnlockAttendance, adminOnly)
+
+	// Chính sách đi muộn/vắng mặt theo khoá học, ghi đè theo lớp
+	api.GET("/courses/:course_id/attendance-policy", controllers.GetAttendancePolicy, staff)
+	api.PUT("/courses/:course_id/attendance-policy", controllers.UpdateAttendancePolicy, staff)
+	api.DELETE("/courses/:course_id/attendance-policy", controllers.DeleteAttendancePolicy, staff)
+	api.GET("/classes/:class_id/attendance-policy", controllers.GetAttendancePolicy, staff)
+	api.PUT("/classes/:class_id/attendance-policy", controllers.UpdateAttendancePolicy, staff)
+	api.DELETE("/classes/:class_id/attendance-policy", controllers.DeleteAttendancePolicy, staff)
+
+	api.GET("/students-in-class/:lecturer_id", controllers.GetStudentsInClass, staff, ownsLecturer)
+	api.PUT("/update/student/:id", controllers.UpdateStudent, staff)
+	api.DELETE("/del-student-from-class/:student_id/:class_id", controllers.DeleteStudentFromClass, staff)
+	api.GET("/check-student-existence/:studentCode", controllers.CheckStudentExistence, staff)
 	// Thêm route cho API add-student-to-class
-	e.POST("/add-student-to-class", controllers.AddStudentToClass)
-	e.GET("/student-attendance-summary/:lecturer_id", controllers.GetStudentAttendanceSummary)
-	e.GET("/get-student-attendances/:student_id/:lecturer_id", controllers.GetStudentAttendances)
-	e.GET("/get-classrooms", controllers.GetClassrooms)
-	e.GET("/get-schedules", controllers.GetSchedules)
-	e.GET("/get-courses-by-lecturerID", controllers.GetCoursesByLecturerID)
-	e.GET("/get-class-by-course-id", controllers.GetClassesByCourses)
-
-	e.POST("/add-schedule", controllers.AddSubject)
-	e.PUT("update-schedule/:id", controllers.UpdateSubject)
-	e.DELETE("/delete-schedule/:id", controllers.DeleteSchedule)
-	e.GET("/get-schedule-start-times", controllers.GetScheduleStartTimes)
-	e.GET("/get-schedule-times", controllers.GetScheduTimes)
-	e.GET("/get-attendance-socket-path", controllers.GetCameraSocketPath)
-	e.GET("/get-human-couter-socket-path", controllers.GetHumanCouterSocketPath)
-	e.GET("/get-snapshot-details", controllers.GetSnapshotDetails)
+	api.POST("/add-student-to-class", controllers.AddStudentToClass, staff)
+	api.GET("/student-attendance-summary/:lecturer_id", controllers.GetStudentAttendanceSummary, staff, ownsLecturer)
+	api.GET("/student-attendance-summary/:lecturer_id/export", controllers.ExportStudentAttendanceSummary, staff, ownsLecturer)
+	api.GET("/get-student-attendances/:student_id/:lecturer_id", controllers.GetStudentAttendances, everyone,
+		middleware.OwnershipMiddleware("student_id", "lecturer_id"))
+	api.GET("/get-classrooms", controllers.GetClassrooms, everyone)
+	api.GET("/get-schedules", controllers.GetSchedules, everyone, ownsLecturer)
+	api.GET("/get-courses-by-lecturerID", controllers.GetCoursesByLecturerID, staff, ownsLecturer)
+	api.GET("/get-class-by-course-id", controllers.GetClassesByCourses, staff)
+
+	api.POST("/add-schedule", controllers.AddSubject, staff)
+	api.PUT("/update-schedule/:id", controllers.UpdateSubject, staff)
+	api.DELETE("/delete-schedule/:id", controllers.DeleteSchedule, staff)
+	api.GET("/get-schedule-start-times", controllers.GetScheduleStartTimes, everyone, ownsLecturer)
+	api.GET("/get-schedule-times", controllers.GetScheduTimes, everyone)
+	api.GET("/get-attendance-socket-path", controllers.GetCameraSocketPath, staff)
+	api.GET("/get-human-couter-socket-path", controllers.GetHumanCouterSocketPath, staff)
+	api.GET("/get-snapshot-details", controllers.GetSnapshotDetails, staff, ownsLecturer)
+
+	// Nhận dạng khuôn mặt 1:N
+	api.POST("/faces/identify", controllers.IdentifyFace, recognition)
+
+	// Camera nhận dạng gửi sự kiện, backend tự tạo bản ghi điểm danh
+	api.POST("/recognition/events", controllers.IngestRecognitionEvent, middleware.RoleMiddleware("admin", "service"))
+
+	// Đăng ký khuôn mặt (nhiều embedding cho mỗi người)
+	faceEnrollment := middleware.RoleMiddleware("admin", "service")
+	api.POST("/users/:user_id/face-embeddings", controllers.AddFaceEmbedding, faceEnrollment)
+	api.GET("/users/:user_id/face-embeddings", controllers.GetFaceEmbeddings, faceEnrollment)
+	api.DELETE("/users/:user_id/face-embeddings/:id", controllers.DeleteFaceEmbedding, faceEnrollment)
+	api.PUT("/users/:user_id/face-embeddings/:id/primary", controllers.SetPrimaryFaceEmbedding, faceEnrollment)
+
+	// Quản trị dữ liệu sinh trắc học: đồng ý, log truy cập, quyền được xoá
+	api.GET("/me/biometric-consent", controllers.GetMyBiometricConsent)
+	api.PUT("/me/biometric-consent", controllers.UpdateMyBiometricConsent)
+	api.GET("/users/:user_id/biometric-consent", controllers.GetUserBiometricConsent, adminOnly)
+	api.DELETE("/users/:user_id/biometric-data", controllers.EraseBiometricData, adminOnly)
+	api.GET("/biometric-access-logs", controllers.GetBiometricAccessLogs, adminOnly)
+
+	// Phiên bản mô hình nhận dạng
+	api.GET("/face-models", controllers.GetFaceModels, recognition)
+	api.POST("/face-models", controllers.CreateFaceModel, adminOnly)
+	api.PUT("/face-models/:id/activate", controllers.ActivateFaceModel, adminOnly)
+	api.GET("/face-models/reenrollment-status", controllers.GetReenrollmentStatus, adminOnly)
 }
 
 // userId:"2d536da8-fdf3-437b-a812-fb4e08aad955"
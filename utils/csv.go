@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// utf8BOM giúp Excel nhận đúng tiếng Việt có dấu khi mở file CSV.
+const utf8BOM = "\xEF\xBB\xBF"
+
+// NewCSVWriter tạo csv.Writer ghi thẳng ra w (ví dụ http.ResponseWriter), kèm BOM UTF-8 ở đầu.
+func NewCSVWriter(w io.Writer) (*csv.Writer, error) {
+	if _, err := io.WriteString(w, utf8BOM); err != nil {
+		return nil, err
+	}
+	return csv.NewWriter(w), nil
+}
+
+// CSVText thêm dấu ' trước chuỗi bắt đầu bằng =, +, -, @ (hoặc tab, CR) để Excel không hiểu
+// dữ liệu người dùng nhập (tên, ghi chú, ...) thành công thức khi mở file.
+func CSVText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
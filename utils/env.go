@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// GetEnvInt đọc biến môi trường kiểu số nguyên, trả về def nếu không có hoặc không hợp lệ.
+func GetEnvInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
+
+// GetEnvDuration đọc biến môi trường dạng time.Duration (ví dụ "15m"), trả về def nếu không hợp lệ.
+func GetEnvDuration(key string, def time.Duration) time.Duration {
+	if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/xuri/excelize/v2"
+)
+
+// Định dạng file xuất
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+	ExportPDF  = "pdf"
+)
+
+var (
+	ErrUnsupportedExportFormat = errors.New("format must be csv, xlsx or pdf")
+	ErrExportTooLarge          = errors.New("too many rows for a PDF export, use csv or xlsx")
+)
+
+// ExportColumn là một cột của bảng xuất. Width là độ rộng tính theo số ký tự
+// (dùng trực tiếp cho XLSX, quy đổi theo tỉ lệ cho PDF).
+type ExportColumn struct {
+	Title string
+	Width float64
+}
+
+// ExportMeta là một dòng thông tin ở đầu file (ví dụ "Lớp: ...", "Giảng viên: ...").
+type ExportMeta struct {
+	Label string
+	Value string
+}
+
+// Exporter ghi một hoặc nhiều bảng ra file CSV/XLSX/PDF. Dữ liệu được ghi theo từng dòng
+// để không phải dựng toàn bộ báo cáo trong bộ nhớ.
+type Exporter interface {
+	// Table bắt đầu một bảng mới (CSV: một đoạn mới, XLSX: một sheet mới, PDF: một mục mới).
+	Table(title string, columns []ExportColumn) error
+	// Row ghi một dòng; giá trị là string, số nguyên hoặc số thực.
+	Row(values ...interface{}) error
+	// Close hoàn tất file và ghi phần còn lại ra writer.
+	Close() error
+}
+
+// ExportContentType trả về Content-Type tương ứng với định dạng xuất.
+func ExportContentType(format string) string {
+	switch format {
+	case ExportXLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case ExportPDF:
+		return "application/pdf"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// NewExporter tạo Exporter ghi ra w. title và meta được in ở đầu file.
+// PDF được dựng trong bộ nhớ nên bị giới hạn bởi EXPORT_PDF_MAX_ROWS dòng (mặc định 5000).
+func NewExporter(format string, w io.Writer, title string, meta []ExportMeta) (Exporter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVExporter(w, title, meta)
+	case ExportXLSX:
+		return &xlsxExporter{w: w, file: excelize.NewFile(), title: title, meta: meta}, nil
+	case ExportPDF:
+		return newPDFExporter(w, title, meta), nil
+	}
+	return nil, ErrUnsupportedExportFormat
+}
+
+// exportString chuyển giá trị của một ô sang chuỗi để ghi CSV/PDF.
+func exportString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format("02/01/2006 15:04")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// csvExporter ghi thẳng ra writer; các bảng cách nhau bởi một dòng trống.
+type csvExporter struct {
+	cw     *csv.Writer
+	tables int
+}
+
+func newCSVExporter(w io.Writer, title string, meta []ExportMeta) (*csvExporter, error) {
+	cw, err := NewCSVWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	_ = cw.Write([]string{CSVText(title)})
+	for _, m := range meta {
+		_ = cw.Write([]string{m.Label, CSVText(m.Value)})
+	}
+	return &csvExporter{cw: cw}, cw.Error()
+}
+
+func (e *csvExporter) Table(title string, columns []ExportColumn) error {
+	_ = e.cw.Write([]string{})
+	if e.tables > 0 || title != "" {
+		_ = e.cw.Write([]string{CSVText(title)})
+	}
+	e.tables++
+	header := make([]string, len(columns))
+	for i, col := range columns {
+		header[i] = col.Title
+	}
+	_ = e.cw.Write(header)
+	return e.cw.Error()
+}
+
+func (e *csvExporter) Row(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			record[i] = CSVText(s)
+		} else {
+			record[i] = exportString(v)
+		}
+	}
+	return e.cw.Write(record)
+}
+
+func (e *csvExporter) Close() error {
+	e.cw.Flush()
+	return e.cw.Error()
+}
+
+// xlsxExporter dùng StreamWriter của excelize: các dòng được đẩy ra file tạm thay vì giữ trong bộ nhớ.
+type xlsxExporter struct {
+	w      io.Writer
+	file   *excelize.File
+	sw     *excelize.StreamWriter
+	title  string
+	meta   []ExportMeta
+	row    int
+	sheets int
+	used   map[string]bool // Tên sheet đã dùng (không phân biệt hoa thường như Excel)
+	bold   int
+	text   int
+}
+
+// xlsxSheetName tạo tên sheet hợp lệ (tối đa 31 ký tự, không chứa ký tự đặc biệt, không bắt đầu
+// hay kết thúc bằng dấu ') và không trùng với các sheet đã có: tên bị cắt có thể trùng nhau,
+// khi đó thêm hậu tố " (2)", " (3)", ...
+func xlsxSheetName(title string, index int, used map[string]bool) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return ' '
+		}
+		return r
+	}, title)
+	name = strings.TrimSpace(strings.Trim(strings.TrimSpace(name), "'"))
+	if name == "" {
+		name = fmt.Sprintf("Sheet%d", index)
+	}
+
+	candidate := name
+	for n := 2; ; n++ {
+		if runes := []rune(candidate); len(runes) > 31 {
+			candidate = strings.TrimSpace(strings.TrimRight(string(runes[:31]), "'"))
+		}
+		if !used[strings.ToLower(candidate)] {
+			return candidate
+		}
+		suffix := fmt.Sprintf(" (%d)", n)
+		base := []rune(name)
+		if len(base)+len(suffix) > 31 {
+			base = base[:31-len(suffix)]
+		}
+		candidate = strings.TrimSpace(strings.TrimRight(string(base), "'")) + suffix
+	}
+}
+
+func (e *xlsxExporter) Table(title string, columns []ExportColumn) error {
+	if e.sw != nil {
+		if err := e.sw.Flush(); err != nil {
+			return err
+		}
+	}
+	e.sheets++
+	if e.used == nil {
+		e.used = map[string]bool{}
+	}
+	name := xlsxSheetName(title, e.sheets, e.used)
+	e.used[strings.ToLower(name)] = true
+	if e.sheets == 1 {
+		if err := e.file.SetSheetName("Sheet1", name); err != nil {
+			return err
+		}
+		bold, err := e.file.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+		if err != nil {
+			return err
+		}
+		// Định dạng "@" (Text): chuỗi luôn là văn bản, không bao giờ được hiểu thành công thức
+		text, err := e.file.NewStyle(&excelize.Style{NumFmt: 49})
+		if err != nil {
+			return err
+		}
+		e.bold, e.text = bold, text
+	} else if _, err := e.file.NewSheet(name); err != nil {
+		return err
+	}
+
+	sw, err := e.file.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	e.sw, e.row = sw, 0
+	for i, col := range columns {
+		if col.Width > 0 {
+			if err := sw.SetColWidth(i+1, i+1, col.Width); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Tiêu đề và thông tin chung được lặp lại ở đầu mỗi sheet
+	if err := e.writeRow([]interface{}{excelize.Cell{StyleID: e.bold, Value: e.title}}); err != nil {
+		return err
+	}
+	for _, m := range e.meta {
+		if err := e.writeRow([]interface{}{m.Label, excelize.Cell{StyleID: e.text, Value: m.Value}}); err != nil {
+			return err
+		}
+	}
+	if title != "" && title != e.title {
+		e.row++
+		if err := e.writeRow([]interface{}{excelize.Cell{StyleID: e.bold, Value: title}}); err != nil {
+			return err
+		}
+	}
+	e.row++
+	header := make([]interface{}, len(columns))
+	for i, col := range columns {
+		header[i] = excelize.Cell{StyleID: e.bold, Value: col.Title}
+	}
+	return e.writeRow(header)
+}
+
+func (e *xlsxExporter) writeRow(values []interface{}) error {
+	e.row++
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+	return e.sw.SetRow(cell, values)
+}
+
+func (e *xlsxExporter) Row(values ...interface{}) error {
+	if e.sw == nil {
+		return errors.New("xlsx export: Table must be called before Row")
+	}
+	cells := make([]interface{}, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			cells[i] = excelize.Cell{StyleID: e.text, Value: s}
+		} else {
+			cells[i] = v
+		}
+	}
+	return e.writeRow(cells)
+}
+
+func (e *xlsxExporter) Close() error {
+	defer e.file.Close()
+	if e.sw != nil {
+		if err := e.sw.Flush(); err != nil {
+			return err
+		}
+	}
+	return e.file.Write(e.w)
+}
+
+// pdfExporter dựng bảng khổ A4 ngang, lặp lại dòng tiêu đề cột ở mỗi trang.
+type pdfExporter struct {
+	w       io.Writer
+	pdf     *gofpdf.Fpdf
+	columns []ExportColumn
+	widths  []float64
+	rows    int
+	maxRows int
+}
+
+const pdfRowHeight = 6.0
+
+func newPDFExporter(w io.Writer, title string, meta []ExportMeta) *pdfExporter {
+	pdf := NewPDF("L")
+	pdf.AddPage()
+	pdf.SetFont(PDFFont, "B", 14)
+	pdf.MultiCell(0, 7, PDFText(title), "", "C", false)
+	pdf.SetFont(PDFFont, "", 10)
+	for _, m := range meta {
+		pdf.MultiCell(0, 5, PDFText(m.Label+": "+m.Value), "", "L", false)
+	}
+	return &pdfExporter{w: w, pdf: pdf, maxRows: GetEnvInt("EXPORT_PDF_MAX_ROWS", 5000)}
+}
+
+func (e *pdfExporter) Table(title string, columns []ExportColumn) error {
+	e.columns = columns
+	pageWidth, _ := e.pdf.GetPageSize()
+	left, _, right, _ := e.pdf.GetMargins()
+	total := 0.0
+	for _, col := range columns {
+		total += col.Width
+	}
+	e.widths = make([]float64, len(columns))
+	for i, col := range columns {
+		e.widths[i] = (pageWidth - left - right) * col.Width / total
+	}
+
+	e.pdf.Ln(4)
+	if title != "" {
+		e.pdf.SetFont(PDFFont, "B", 11)
+		e.pdf.CellFormat(0, 7, PDFText(title), "", 1, "L", false, 0, "")
+	}
+	e.header()
+	return e.pdf.Error()
+}
+
+// header vẽ dòng tiêu đề cột của bảng hiện tại.
+func (e *pdfExporter) header() {
+	e.pdf.SetFont(PDFFont, "B", 9)
+	e.pdf.SetFillColor(230, 230, 230)
+	for i, col := range e.columns {
+		e.pdf.CellFormat(e.widths[i], pdfRowHeight+1, FitPDFText(e.pdf, col.Title, e.widths[i]-1), "1", 0, "C", true, 0, "")
+	}
+	e.pdf.Ln(-1)
+	e.pdf.SetFont(PDFFont, "", 9)
+}
+
+func (e *pdfExporter) Row(values ...interface{}) error {
+	e.rows++
+	if e.rows > e.maxRows {
+		return ErrExportTooLarge
+	}
+	_, pageHeight := e.pdf.GetPageSize()
+	_, _, _, bottom := e.pdf.GetMargins()
+	if e.pdf.GetY()+pdfRowHeight > pageHeight-bottom {
+		e.pdf.AddPage()
+		e.header()
+	}
+	for i := range e.columns {
+		var value interface{}
+		if i < len(values) {
+			value = values[i]
+		}
+		align := "L"
+		switch value.(type) {
+		case int, int64, float64:
+			align = "R"
+		}
+		e.pdf.CellFormat(e.widths[i], pdfRowHeight, FitPDFText(e.pdf, exportString(value), e.widths[i]-1), "1", 0, align, false, 0, "")
+	}
+	e.pdf.Ln(-1)
+	return e.pdf.Error()
+}
+
+func (e *pdfExporter) Close() error {
+	return e.pdf.Output(e.w)
+}
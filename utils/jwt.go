@@ -2,31 +2,71 @@
 package utils
 
 import (
-	"os"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"cms-backend/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Giá trị của claim "typ", dùng để phân biệt access token và refresh token
+const (
+	TokenTypeAccess     = "access"
+	TokenTypeRefresh    = "refresh"
+	TokenTypeMFAPending = "mfa_pending"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+const (
+	AccessTokenTTL     = 15 * time.Minute
+	RefreshTokenTTL    = 7 * 24 * time.Hour
+	MFAPendingTokenTTL = 5 * time.Minute
+)
 
 func GenerateAccessToken(user models.User) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": user.UserID.String(),
 		"role":    user.Role,
-		"exp":     time.Now().Add(15 * time.Minute).Unix(),
+		"typ":     TokenTypeAccess,
+		"exp":     time.Now().Add(AccessTokenTTL).Unix(),
+	}
+	return defaultSigner.Sign(claims)
+}
+
+// GenerateMFAPendingToken tạo token tạm sau khi đúng mật khẩu, chỉ dùng được để
+// xác minh mã TOTP hoặc đăng ký TOTP, không dùng được để gọi các API khác.
+func GenerateMFAPendingToken(user models.User) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": user.UserID.String(),
+		"role":    user.Role,
+		"typ":     TokenTypeMFAPending,
+		"exp":     time.Now().Add(MFAPendingTokenTTL).Unix(),
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return defaultSigner.Sign(claims)
 }
 
-func GenerateRefreshToken(user models.User) (string, error) {
+// GenerateRefreshToken tạo refresh token mang tokenID (jti) và familyID (fid)
+// tương ứng với bản ghi models.RefreshToken được lưu phía server.
+func GenerateRefreshToken(user models.User, tokenID, familyID uuid.UUID) (string, error) {
 	claims := jwt.MapClaims{
 		"user_id": user.UserID.String(),
 		"role":    user.Role,
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
+		"typ":     TokenTypeRefresh,
+		"jti":     tokenID.String(),
+		"fid":     familyID.String(),
+		"exp":     time.Now().Add(RefreshTokenTTL).Unix(),
+	}
+	return defaultSigner.Sign(claims)
+}
+
+// ParseToken kiểm tra chữ ký, hạn dùng và loại token (claim "typ").
+func ParseToken(tokenString, expectedType string) (jwt.MapClaims, error) {
+	claims, err := defaultSigner.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := claims["typ"].(string); typ != expectedType {
+		return nil, jwt.ErrTokenInvalidClaims
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return claims, nil
 }
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Font DejaVu Sans hỗ trợ đầy đủ tiếng Việt, được nhúng vào binary để không phụ thuộc font của hệ thống.
+var (
+	//go:embed fonts/DejaVuSansCondensed.ttf
+	dejaVuRegular []byte
+	//go:embed fonts/DejaVuSansCondensed-Bold.ttf
+	dejaVuBold []byte
+)
+
+// PDFFont là tên font dùng với SetFont trên tài liệu tạo bởi NewPDF (kiểu "" hoặc "B").
+const PDFFont = "DejaVu"
+
+// NewPDF tạo tài liệu PDF khổ A4 (orientation "P" hoặc "L") đã nạp font tiếng Việt,
+// kèm số trang ở chân trang.
+func NewPDF(orientation string) *gofpdf.Fpdf {
+	pdf := gofpdf.New(orientation, "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes(PDFFont, "", dejaVuRegular)
+	pdf.AddUTF8FontFromBytes(PDFFont, "B", dejaVuBold)
+	pdf.SetFont(PDFFont, "", 10)
+	pdf.SetMargins(12, 12, 12)
+	pdf.SetAutoPageBreak(true, 15)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont(PDFFont, "", 8)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Trang %d/{nb}", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	return pdf
+}
+
+// PDFText chuẩn hoá chuỗi về dạng dựng sẵn (NFC) để dấu tiếng Việt tổ hợp được hiển thị đúng.
+func PDFText(s string) string {
+	return norm.NFC.String(s)
+}
+
+// FitPDFText cắt chuỗi cho vừa độ rộng width (mm) với font hiện tại, thêm "…" nếu bị cắt.
+func FitPDFText(pdf *gofpdf.Fpdf, s string, width float64) string {
+	s = PDFText(s)
+	if pdf.GetStringWidth(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	for len(runes) > 0 && pdf.GetStringWidth(string(runes)+"…") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "…"
+}
+
+// PDFQRCode vẽ mã QR chứa content tại (x, y) với cạnh size (mm).
+func PDFQRCode(pdf *gofpdf.Fpdf, content string, x, y, size float64) error {
+	png, err := qrcode.Encode(content, qrcode.Medium, 512)
+	if err != nil {
+		return err
+	}
+	options := gofpdf.ImageOptions{ImageType: "PNG"}
+	name := "qr:" + content
+	pdf.RegisterImageOptionsReader(name, options, bytes.NewReader(png))
+	pdf.ImageOptions(name, x, y, size, size, false, options, 0, "")
+	return pdf.Error()
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// RotatingTokenStep trả về chỉ số bước thời gian hiện tại với chu kỳ period.
+func RotatingTokenStep(t time.Time, period time.Duration) int64 {
+	return t.Unix() / int64(period/time.Second)
+}
+
+// SignRotatingToken ký (HMAC-SHA256) cặp subject + step, trả về chữ ký base64url.
+// Dùng cho mã QR xoay vòng: mỗi bước thời gian có một chữ ký khác nhau.
+func SignRotatingToken(secret []byte, subject string, step int64) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(subject))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(step, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyRotatingToken kiểm tra chữ ký của subject tại bước step, chấp nhận step nằm trong
+// khoảng [current-skew, current] để bù độ trễ khi quét mã.
+func VerifyRotatingToken(secret []byte, subject string, step int64, signature string, current int64, skew int64) bool {
+	if step > current || step < current-skew {
+		return false
+	}
+	expected := SignRotatingToken(secret, subject, step)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
@@ -0,0 +1,253 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer ký JWT bằng khoá bất đối xứng (RS256 hoặc EdDSA) và giữ nhiều khoá
+// công khai để xác thực trong thời gian xoay vòng khoá. Mỗi token mang header "kid"
+// để biết cần dùng khoá công khai nào.
+type Signer struct {
+	mu         sync.RWMutex
+	issuer     string
+	activeKID  string
+	signingKey crypto.Signer
+	publicKeys map[string]crypto.PublicKey
+}
+
+var defaultSigner *Signer
+
+// SetSigner đặt Signer dùng chung cho các hàm tạo và kiểm tra token.
+func SetSigner(s *Signer) {
+	defaultSigner = s
+}
+
+// DefaultSigner trả về Signer dùng chung.
+func DefaultSigner() *Signer {
+	return defaultSigner
+}
+
+// NewSigner tạo Signer với khoá ký activeKID và tập khoá công khai dùng để xác thực.
+func NewSigner(issuer, activeKID string, signingKey crypto.Signer, publicKeys map[string]crypto.PublicKey) (*Signer, error) {
+	if _, err := signingMethodFor(signingKey.Public()); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]crypto.PublicKey{activeKID: signingKey.Public()}
+	for kid, key := range publicKeys {
+		if _, err := signingMethodFor(key); err != nil {
+			return nil, fmt.Errorf("key %s: %w", kid, err)
+		}
+		if kid != activeKID {
+			keys[kid] = key
+		}
+	}
+
+	return &Signer{
+		issuer:     issuer,
+		activeKID:  activeKID,
+		signingKey: signingKey,
+		publicKeys: keys,
+	}, nil
+}
+
+// LoadSignerFromDir đọc các khoá PEM trong dir. File "<kid>.pem" chứa khoá bí mật (PKCS#8
+// hoặc PKCS#1), file "<kid>.pub.pem" chứa khoá công khai của khoá đã ngừng ký nhưng vẫn
+// cần xác thực token cũ. activeKID là khoá được dùng để ký.
+func LoadSignerFromDir(dir, activeKID, issuer string) (*Signer, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var signingKey crypto.Signer
+	publicKeys := map[string]crypto.PublicKey{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".pem") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		if kid, ok := strings.CutSuffix(name, ".pub.pem"); ok {
+			key, err := parsePublicKeyPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			publicKeys[kid] = key
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, ".pem")
+		key, err := parsePrivateKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		publicKeys[kid] = key.Public()
+		if kid == activeKID {
+			signingKey = key
+		}
+	}
+
+	if signingKey == nil {
+		return nil, fmt.Errorf("private key for active kid %q not found in %s", activeKID, dir)
+	}
+	return NewSigner(issuer, activeKID, signingKey, publicKeys)
+}
+
+// Sign ký claims bằng khoá đang hoạt động, kèm header "kid" và claim "iss".
+func (s *Signer) Sign(claims jwt.MapClaims) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	method, err := signingMethodFor(s.signingKey.Public())
+	if err != nil {
+		return "", err
+	}
+	if s.issuer != "" {
+		claims["iss"] = s.issuer
+	}
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = s.activeKID
+	return token.SignedString(s.signingKey)
+}
+
+// Parse kiểm tra chữ ký theo "kid" trong header, hạn dùng và issuer của token.
+func (s *Signer) Parse(tokenString string) (jwt.MapClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	}
+	if s.issuer != "" {
+		options = append(options, jwt.WithIssuer(s.issuer))
+	}
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		s.mu.RLock()
+		key, ok := s.publicKeys[kid]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+
+		// Không cho phép đổi thuật toán so với loại khoá (ví dụ ký EdDSA bằng khoá RSA)
+		method, err := signingMethodFor(key)
+		if err != nil || method.Alg() != token.Method.Alg() {
+			return nil, errors.New("signing method does not match key")
+		}
+		return key, nil
+	}, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// JWK là biểu diễn JSON Web Key của một khoá công khai.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS trả về tập khoá công khai đang dùng để xác thực token.
+func (s *Signer) JWKS() []JWK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]JWK, 0, len(s.publicKeys))
+	for kid, key := range s.publicKeys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodEdDSA.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(k),
+			})
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+func signingMethodFor(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func parsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+		return signer, nil
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM data")
+	}
+
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS1PublicKey(block.Bytes)
+}
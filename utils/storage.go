@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrFileTooLarge        = errors.New("file too large")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+)
+
+// StoredFile mô tả file đã được lưu vào thư mục upload.
+type StoredFile struct {
+	FileName    string // Tên file gốc do người dùng gửi lên
+	ContentType string
+	Size        int64
+	Path        string // Đường dẫn tương đối trong thư mục upload
+}
+
+// UploadDir trả về thư mục lưu file upload (UPLOAD_DIR, mặc định "uploads").
+func UploadDir() string {
+	if dir := os.Getenv("UPLOAD_DIR"); dir != "" {
+		return dir
+	}
+	return "uploads"
+}
+
+// SaveUpload lưu file upload vào UploadDir()/subdir với tên ngẫu nhiên. Kiểu file được xác định
+// từ nội dung (không tin Content-Type của client) và phải nằm trong danh sách allowedTypes.
+func SaveUpload(fh *multipart.FileHeader, subdir string, allowedTypes []string, maxSize int64) (StoredFile, error) {
+	if fh.Size > maxSize {
+		return StoredFile{}, ErrFileTooLarge
+	}
+
+	src, err := fh.Open()
+	if err != nil {
+		return StoredFile{}, err
+	}
+	defer src.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return StoredFile{}, err
+	}
+	contentType := http.DetectContentType(head[:n])
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	allowed := false
+	for _, t := range allowedTypes {
+		if t == contentType {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return StoredFile{}, ErrUnsupportedFileType
+	}
+
+	if err := os.MkdirAll(filepath.Join(UploadDir(), subdir), 0o750); err != nil {
+		return StoredFile{}, err
+	}
+	rel := filepath.ToSlash(filepath.Join(subdir, uuid.NewString()+strings.ToLower(filepath.Ext(fh.Filename))))
+	dst, err := os.OpenFile(filepath.Join(UploadDir(), filepath.FromSlash(rel)), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return StoredFile{}, err
+	}
+	defer dst.Close()
+
+	// Ghi tối đa maxSize byte, phòng trường hợp Size trong header không đúng
+	size, err := io.Copy(dst, io.LimitReader(io.MultiReader(bytes.NewReader(head[:n]), src), maxSize+1))
+	if err == nil && size > maxSize {
+		err = ErrFileTooLarge
+	}
+	if err != nil {
+		dst.Close()
+		os.Remove(filepath.Join(UploadDir(), filepath.FromSlash(rel)))
+		return StoredFile{}, err
+	}
+
+	return StoredFile{
+		FileName:    filepath.Base(fh.Filename),
+		ContentType: contentType,
+		Size:        size,
+		Path:        rel,
+	}, nil
+}
+
+// UploadPath trả về đường dẫn tuyệt đối (trong UploadDir()) của file đã lưu.
+func UploadPath(rel string) string {
+	return filepath.Join(UploadDir(), filepath.FromSlash(rel))
+}
+
+// RemoveUpload xoá file đã lưu; bỏ qua nếu file không còn tồn tại.
+func RemoveUpload(rel string) error {
+	if err := os.Remove(UploadPath(rel)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}